@@ -0,0 +1,231 @@
+package ssh
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ------------------------------ Become ------------------------------
+
+// BecomeFailed is returned when privilege escalation (see [WithBecome])
+// fails before the command could be run (as opposed to the command
+// itself failing once running as the other user).
+type BecomeFailed struct {
+	Method string
+	User   string
+	Reason string
+}
+
+func (e BecomeFailed) Error() string {
+	return fmt.Sprintf(`%v to %v failed: %v`, e.Method, e.User, e.Reason)
+}
+
+// Become contains the settings for running a command as another user
+// (see [WithBecome]).
+type Become struct {
+
+	// Method is either sudo (default) or su.
+	Method string
+
+	// User to become. Defaults to root.
+	User string
+
+	// Password sent when prompted by sudo or su (optional). When empty
+	// sudo is run non-interactively (sudo -n) and fails instead of
+	// prompting.
+	Password string
+
+	// TTY requests a pseudo-terminal for sudo (which su always gets) for
+	// hosts with requiretty in their sudoers configuration. Standard
+	// output and error are combined into Stdout when a pseudo-terminal
+	// is used.
+	TTY bool
+}
+
+// WithBecome runs the command as another user by wrapping it in sudo or
+// su (see [Become]). The password is written to the standard input of
+// sudo (sudo -S with a unique prompt detected on standard error) or to
+// the pseudo-terminal of su only when actually prompted for, and never
+// appears in the command line. Standard input for the command itself is
+// only sent once escalation has succeeded. Environment variables (see
+// [WithEnv]) are always set inside the escalated shell since sudo and
+// su reset the environment. If escalation fails (wrong or missing
+// password, not permitted, etc.) a [BecomeFailed] error is returned and
+// the command is never run. Only supported for the POSIX dialect.
+func WithBecome(b Become) RunOption {
+	return func(c *runConfig) { c.become = &b }
+}
+
+// suPrompt matches the end of a password prompt printed by su.
+var suPrompt = regexp.MustCompile(`(?i)password[^:\n]*:\s*$`)
+
+// becomer watches the output stream on which sudo or su prompt for
+// a password until the escalated shell prints the unique mark and then
+// passes everything after it through to next.
+type becomer struct {
+	Become
+	cmd    string
+	mark   string
+	prompt string
+	pty    bool
+	next   io.Writer
+	stdin  io.WriteCloser
+	input  []byte
+	abort  func()
+
+	mu       sync.Mutex
+	pending  []byte
+	prompted int
+	ready    bool
+	failure  string
+}
+
+func newBecomer(b *Become) *becomer {
+	x := &becomer{Become: *b}
+	if len(x.Method) == 0 {
+		x.Method = `sudo`
+	}
+	if len(x.User) == 0 {
+		x.User = `root`
+	}
+	id := make([]byte, 8)
+	rand.Read(id)
+	x.mark = `__become_` + hex.EncodeToString(id) + `__`
+	x.prompt = `__become_prompt_` + hex.EncodeToString(id) + `__:`
+	x.pty = x.TTY || x.Method == `su`
+	return x
+}
+
+// escalate sets up the session of the command to become another user
+// and returns the becomer holding the wrapped command line.
+func (x *Command) escalate(cmd string, stdin []byte, b *Become) (*becomer, error) {
+	if x.client.Dialect != POSIX {
+		return nil, fmt.Errorf(`become not supported for dialect: %v`, x.client.Dialect)
+	}
+	w := newBecomer(b)
+	var err error
+	if w.cmd, err = w.wrap(cmd); err != nil {
+		return nil, err
+	}
+	if w.pty {
+		if err := x.sess.RequestPty(DefaultTerm, 0, 0, becomeModes); err != nil {
+			return nil, err
+		}
+	}
+	if w.stdin, err = x.sess.StdinPipe(); err != nil {
+		return nil, err
+	}
+	w.input = stdin
+	w.abort = func() { x.sess.Close() }
+	if w.pty {
+		w.next = x.sess.Stdout
+		x.sess.Stdout = w
+	} else {
+		w.next = x.sess.Stderr
+		x.sess.Stderr = w
+	}
+	return w, nil
+}
+
+// wrap returns cmd wrapped in sudo or su printing the mark first.
+func (x *becomer) wrap(cmd string) (string, error) {
+	stream := ` >&2`
+	if x.pty {
+		stream = ``
+	}
+	inner := `printf '%s\n' ` + x.mark + stream + `; ` + cmd
+	switch x.Method {
+	case `sudo`:
+		flags := `-n`
+		if len(x.Password) > 0 {
+			flags = `-S -p ` + POSIX.Quote(x.prompt)
+		}
+		return `sudo ` + flags + ` -u ` + POSIX.Quote(x.User) + ` -- sh -c ` + POSIX.Quote(inner), nil
+	case `su`:
+		return `su ` + POSIX.Quote(x.User) + ` -c ` + POSIX.Quote(inner), nil
+	}
+	return ``, fmt.Errorf(`unsupported become method: %v`, x.Method)
+}
+
+// becomeModes are the terminal modes used when a pseudo-terminal is
+// needed so that input is not echoed and newlines are not translated.
+var becomeModes = ssh.TerminalModes{ssh.ECHO: 0, ssh.ONLCR: 0}
+
+func (x *becomer) Write(p []byte) (int, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.ready {
+		return x.next.Write(p)
+	}
+	n := len(p)
+	x.pending = append(x.pending, p...)
+	if i := bytes.Index(x.pending, []byte(x.mark+"\n")); i >= 0 {
+		x.ready = true
+		rest := x.pending[i+len(x.mark)+1:]
+		x.pending = x.pending[:i]
+		go x.send()
+		if len(rest) > 0 {
+			if _, err := x.next.Write(rest); err != nil {
+				return 0, err
+			}
+		}
+		return n, nil
+	}
+	prompted := bytes.HasSuffix(bytes.TrimRight(x.pending, " "), []byte(x.prompt))
+	if x.Method == `su` {
+		prompted = suPrompt.Match(x.pending)
+	}
+	if !prompted {
+		return n, nil
+	}
+	x.prompted++
+	x.pending = x.pending[:0]
+	switch {
+	case len(x.Password) == 0:
+		x.failure = `password required`
+		go x.abort()
+	case x.prompted > 1:
+		x.failure = `incorrect password`
+		go x.abort()
+	default:
+		go io.WriteString(x.stdin, x.Password+"\n")
+	}
+	return n, nil
+}
+
+// send writes the standard input for the command once escalation has
+// succeeded.
+func (x *becomer) send() {
+	if len(x.input) > 0 {
+		x.stdin.Write(x.input)
+		if x.pty {
+			x.stdin.Write([]byte{4}) // EOF (^D)
+		}
+	}
+	x.stdin.Close()
+}
+
+// err returns a BecomeFailed error if escalation never succeeded.
+func (x *becomer) err() error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.ready {
+		return nil
+	}
+	reason := x.failure
+	if len(reason) == 0 {
+		reason = strings.TrimSpace(string(x.pending))
+	}
+	if len(reason) == 0 {
+		reason = `no response`
+	}
+	return BecomeFailed{x.Method, x.User, reason}
+}
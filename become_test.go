@@ -0,0 +1,214 @@
+package ssh
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeStdin records everything written to the standard input of
+// a session by a becomer.
+type fakeStdin struct {
+	mu     sync.Mutex
+	buf    bytes.Buffer
+	closed bool
+}
+
+func (s *fakeStdin) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buf.Write(p)
+}
+
+func (s *fakeStdin) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func (s *fakeStdin) state() (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buf.String(), s.closed
+}
+
+// testBecomer returns a becomer for b wired to fakes along with its
+// stdin, next output, and a function returning whether it was aborted.
+func testBecomer(t *testing.T, b Become, input string) (*becomer, *fakeStdin, *bytes.Buffer, func() bool) {
+	t.Helper()
+	x := newBecomer(&b)
+	if _, err := x.wrap(`id`); err != nil {
+		t.Fatal(err)
+	}
+	stdin := new(fakeStdin)
+	next := new(bytes.Buffer)
+	var mu sync.Mutex
+	var aborted bool
+	x.stdin = stdin
+	x.next = next
+	x.input = []byte(input)
+	x.abort = func() {
+		mu.Lock()
+		aborted = true
+		mu.Unlock()
+	}
+	return x, stdin, next, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return aborted
+	}
+}
+
+// waitFor waits for the condition set from the goroutines started by
+// a becomer.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for i := 0; i < 200; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf(`timed out waiting for %v`, what)
+}
+
+func write(t *testing.T, x *becomer, parts ...string) {
+	t.Helper()
+	for _, p := range parts {
+		if n, err := x.Write([]byte(p)); n != len(p) || err != nil {
+			t.Fatalf(`Write(%q) returned %v, %v`, p, n, err)
+		}
+	}
+}
+
+func TestBecomer_wrap(t *testing.T) {
+	x := newBecomer(&Become{Password: `s3cret'`})
+	cmd, err := x.wrap(`echo hi`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(cmd, `s3cret`) {
+		t.Fatal(`password in command line:`, cmd)
+	}
+	if !strings.HasPrefix(cmd, `sudo -S -p `+POSIX.Quote(x.prompt)+` -u root -- sh -c `) {
+		t.Fatal(cmd)
+	}
+
+	x = newBecomer(&Become{User: `it's`})
+	cmd, _ = x.wrap(`id`)
+	if !strings.HasPrefix(cmd, `sudo -n -u 'it'\''s' -- sh -c `) {
+		t.Fatal(cmd)
+	}
+
+	x = newBecomer(&Become{Method: `su`, Password: `pw`})
+	cmd, _ = x.wrap(`id`)
+	if want := `su root -c 'printf '\''%s\n'\'' ` + x.mark + `; id'`; cmd != want {
+		t.Fatalf("got  %s\nwant %s", cmd, want)
+	}
+
+	if _, err := newBecomer(&Become{Method: `doas`}).wrap(`id`); err == nil {
+		t.Fatal(`unsupported method accepted`)
+	}
+}
+
+func TestBecomer_sudo(t *testing.T) {
+	x, stdin, next, aborted := testBecomer(t, Become{Password: `pw`}, `input`)
+
+	// output before the prompt (such as the sudo lecture) is dropped
+	// and a prompt split across writes is still detected
+	write(t, x, "We trust you have received the usual lecture\n", x.prompt[:7], x.prompt[7:])
+	waitFor(t, `password`, func() bool { s, _ := stdin.state(); return s == "pw\n" })
+
+	// standard input is only sent once the (split) mark is seen and
+	// everything after it is passed through
+	if s, closed := stdin.state(); s != "pw\n" || closed {
+		t.Fatal(`input sent before escalation`)
+	}
+	write(t, x, "\n"+x.mark[:5], x.mark[5:]+"\nerr1", "err2")
+	waitFor(t, `input`, func() bool { _, closed := stdin.state(); return closed })
+	if s, _ := stdin.state(); s != "pw\ninput" {
+		t.Fatalf(`got %q`, s)
+	}
+	if next.String() != `err1err2` {
+		t.Fatalf(`got %q`, next.String())
+	}
+	if err := x.err(); err != nil || aborted() {
+		t.Fatal(err, aborted())
+	}
+}
+
+func TestBecomer_wrongPassword(t *testing.T) {
+	x, stdin, next, aborted := testBecomer(t, Become{Password: `bad`}, `input`)
+	write(t, x, x.prompt)
+	waitFor(t, `password`, func() bool { s, _ := stdin.state(); return s == "bad\n" })
+	write(t, x, "Sorry, try again.\n", x.prompt)
+	waitFor(t, `abort`, aborted)
+	if s, _ := stdin.state(); s != "bad\n" {
+		t.Fatalf(`password sent again or input sent: %q`, s)
+	}
+	var failed BecomeFailed
+	if err := x.err(); !errors.As(err, &failed) || failed.Reason != `incorrect password` {
+		t.Fatal(err)
+	}
+	if next.Len() > 0 {
+		t.Fatalf(`got %q`, next.String())
+	}
+}
+
+func TestBecomer_nonInteractive(t *testing.T) {
+	x, stdin, _, aborted := testBecomer(t, Become{}, ``)
+	write(t, x, "sudo: a password is required\n")
+	var failed BecomeFailed
+	if err := x.err(); !errors.As(err, &failed) || failed.Reason != `sudo: a password is required` {
+		t.Fatal(err)
+	}
+	if s, _ := stdin.state(); s != `` || aborted() {
+		t.Fatal(s, aborted())
+	}
+
+	// no output at all
+	x, _, _, _ = testBecomer(t, Become{}, ``)
+	if err := x.err(); !errors.As(err, &failed) || failed.Reason != `no response` {
+		t.Fatal(err)
+	}
+}
+
+func TestBecomer_su(t *testing.T) {
+	for _, prompt := range []string{`Password: `, `password for root:`, "PASSWORD:\r\n"} {
+		x, stdin, next, _ := testBecomer(t, Become{Method: `su`, Password: `pw`}, `in`)
+		write(t, x, prompt[:3], prompt[3:])
+		waitFor(t, `password for `+prompt, func() bool { s, _ := stdin.state(); return s == "pw\n" })
+		write(t, x, x.mark+"\nout")
+		waitFor(t, `input`, func() bool { _, closed := stdin.state(); return closed })
+		if s, _ := stdin.state(); s != "pw\nin\x04" {
+			t.Fatalf(`got %q`, s)
+		}
+		if next.String() != `out` {
+			t.Fatalf(`got %q`, next.String())
+		}
+	}
+
+	// not a prompt
+	x, stdin, _, _ := testBecomer(t, Become{Method: `su`, Password: `pw`}, ``)
+	write(t, x, "password: changed\n", "Password incorrect\n")
+	time.Sleep(20 * time.Millisecond)
+	if s, _ := stdin.state(); s != `` {
+		t.Fatalf(`password sent without prompt: %q`, s)
+	}
+
+	// prompted without a password
+	x, stdin, _, aborted := testBecomer(t, Become{Method: `su`}, ``)
+	write(t, x, `Password: `)
+	waitFor(t, `abort`, aborted)
+	var failed BecomeFailed
+	if err := x.err(); !errors.As(err, &failed) || failed.Reason != `password required` {
+		t.Fatal(err)
+	}
+	if s, _ := stdin.state(); s != `` {
+		t.Fatalf(`got %q`, s)
+	}
+}
@@ -0,0 +1,186 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultBreakerCooldown is how long the circuit of a client stays open
+// before allowing a trial command when the Breaker has no Cooldown.
+var DefaultBreakerCooldown = 30 * time.Second
+
+// ---------------------------- BreakerState --------------------------
+
+// BreakerState is the state of the circuit breaker of a Client (see
+// [Breaker]).
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota // selected normally
+	BreakerOpen                         // never selected until cooled down
+	BreakerHalfOpen                     // selected for one trial command
+)
+
+var breakerStates = []string{`closed`, `open`, `half-open`}
+
+// String returns closed, open, or half-open.
+func (s BreakerState) String() string {
+	if s < 0 || int(s) >= len(breakerStates) {
+		return fmt.Sprintf(`BreakerState(%d)`, int(s))
+	}
+	return breakerStates[s]
+}
+
+// MarshalText implements [encoding.TextMarshaler].
+func (s BreakerState) MarshalText() ([]byte, error) { return []byte(s.String()), nil }
+
+// UnmarshalText implements [encoding.TextUnmarshaler].
+func (s *BreakerState) UnmarshalText(text []byte) error {
+	for i, name := range breakerStates {
+		if strings.EqualFold(string(text), name) {
+			*s = BreakerState(i)
+			return nil
+		}
+	}
+	return fmt.Errorf(`unknown breaker state: %q`, text)
+}
+
+// ------------------------------ Breaker -----------------------------
+
+// Breaker is the circuit breaker policy of a Controller applied to each
+// of its clients individually. Once Threshold consecutive commands run
+// on a client by the Controller fail to connect or time out (see
+// [CommandTimedOut]) its circuit opens and it is no longer selected
+// (see [Controller.Selector]) even though it may still be connected.
+// After Cooldown the circuit is half-open and the next command is
+// allowed through as a trial which closes the circuit again if it does
+// not fail the same way or reopens it for another Cooldown if it does.
+// Commands exiting with a non-zero status are never counted as
+// failures. A Breaker may be safely marshaled/unmarshaled to/from
+// JSON/YAML.
+type Breaker struct {
+
+	// Threshold is the number of consecutive failures that opens the
+	// circuit. If unset the breaker is disabled.
+	Threshold int
+
+	// Cooldown is how long the circuit stays open. If unset
+	// DefaultBreakerCooldown is used.
+	Cooldown time.Duration
+}
+
+func (b *Breaker) cooldown() time.Duration {
+	if b.Cooldown > 0 {
+		return b.Cooldown
+	}
+	return DefaultBreakerCooldown
+}
+
+// breakerFailure returns true if err counts as a failure of the client
+// for its circuit breaker.
+func breakerFailure(err error) bool {
+	return connectionError(err) || errors.As(err, new(CommandTimedOut))
+}
+
+// Circuit returns the state of the circuit breaker of the client (see
+// [Controller.Breaker]).
+func (c *Client) Circuit() BreakerState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.circuit
+}
+
+// Failures returns the number of consecutive failures counted by the
+// circuit breaker of the client.
+func (c *Client) Failures() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.failures
+}
+
+// selectable returns true if the circuit breaker allows the client to
+// be selected (without admitting it).
+func (c *Client) selectable(b *Breaker) bool {
+	if b == nil || b.Threshold <= 0 {
+		return true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch c.circuit {
+	case BreakerOpen:
+		return !c.probing && time.Since(c.opened) >= b.cooldown()
+	case BreakerHalfOpen:
+		return !c.probing
+	}
+	return true
+}
+
+// admit returns true if the circuit breaker allows a command to be run
+// on the selected client, moving an open circuit that has cooled down
+// to half-open and reserving its one trial command, along with whether
+// the state changed.
+func (c *Client) admit(b *Breaker) (ok, changed bool) {
+	if b == nil || b.Threshold <= 0 {
+		return true, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch c.circuit {
+	case BreakerOpen:
+		if c.probing || time.Since(c.opened) < b.cooldown() {
+			return false, false
+		}
+		c.circuit = BreakerHalfOpen
+		c.probing = true
+		return true, true
+	case BreakerHalfOpen:
+		if c.probing {
+			return false, false
+		}
+		c.probing = true
+	}
+	return true, false
+}
+
+// record counts the outcome of a command run on the client (see
+// [breakerFailure]) and returns whether the state of its circuit
+// changed.
+func (c *Client) record(b *Breaker, err error) (changed bool) {
+	if b == nil || b.Threshold <= 0 {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.probing = false
+	if !breakerFailure(err) {
+		c.failures = 0
+		changed = c.circuit != BreakerClosed
+		c.circuit = BreakerClosed
+		return
+	}
+	c.failures++
+	if c.circuit == BreakerHalfOpen || (c.circuit == BreakerClosed && c.failures >= b.Threshold) {
+		c.circuit = BreakerOpen
+		c.opened = time.Now()
+		return true
+	}
+	return false
+}
+
+// release gives up any trial command reserved for the client without
+// counting an outcome (used when the command is canceled).
+func (c *Client) release() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.probing = false
+}
+
+// record counts the outcome of a command run on client for its circuit
+// breaker and emits an event if the state changed.
+func (c *Controller) record(client *Client, err error) {
+	if client.record(c.Breaker, err) {
+		c.emit(Event{Kind: EventCircuit, Client: client, Err: err})
+	}
+}
@@ -0,0 +1,122 @@
+package ssh
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// cooled moves the time the circuit of client opened back past any
+// cooldown used by the tests.
+func cooled(client *Client) {
+	client.mu.Lock()
+	client.opened = client.opened.Add(-time.Hour)
+	client.mu.Unlock()
+}
+
+func checkCircuit(t *testing.T, client *Client, b *Breaker, state BreakerState, failures int, selectable bool) {
+	t.Helper()
+	if s, f, sel := client.Circuit(), client.Failures(), client.selectable(b); s != state || f != failures || sel != selectable {
+		t.Fatalf(`got %v %v %v want %v %v %v`, s, f, sel, state, failures, selectable)
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	b := &Breaker{Threshold: 2, Cooldown: time.Minute}
+	client := new(Client)
+	refused := &net.OpError{Op: `dial`, Net: `tcp`, Err: errors.New(`refused`)}
+
+	// exiting non-zero is never a failure
+	client.record(b, errors.New(`exit status 1`))
+	checkCircuit(t, client, b, BreakerClosed, 0, true)
+
+	// opens once Threshold consecutive failures are reached
+	if client.record(b, refused) {
+		t.Fatal(`changed before Threshold`)
+	}
+	checkCircuit(t, client, b, BreakerClosed, 1, true)
+	if !client.record(b, refused) {
+		t.Fatal(`not changed at Threshold`)
+	}
+	checkCircuit(t, client, b, BreakerOpen, 2, false)
+
+	// stays open until Cooldown
+	if ok, changed := client.admit(b); ok || changed {
+		t.Fatal(`admitted while open`)
+	}
+
+	// half-open after Cooldown admitting a single trial command
+	cooled(client)
+	checkCircuit(t, client, b, BreakerOpen, 2, true)
+	if ok, changed := client.admit(b); !ok || !changed {
+		t.Fatal(`trial not admitted`)
+	}
+	checkCircuit(t, client, b, BreakerHalfOpen, 2, false)
+	if ok, _ := client.admit(b); ok {
+		t.Fatal(`second trial admitted`)
+	}
+
+	// the trial failing reopens it right away
+	if !client.record(b, CommandTimedOut{Limit: time.Second}) {
+		t.Fatal(`not reopened`)
+	}
+	checkCircuit(t, client, b, BreakerOpen, 3, false)
+
+	// the trial succeeding closes it again
+	cooled(client)
+	if ok, _ := client.admit(b); !ok {
+		t.Fatal(`trial not admitted`)
+	}
+	if !client.record(b, nil) {
+		t.Fatal(`not closed`)
+	}
+	checkCircuit(t, client, b, BreakerClosed, 0, true)
+}
+
+func TestBreaker_release(t *testing.T) {
+	b := &Breaker{Threshold: 1, Cooldown: time.Minute}
+	client := new(Client)
+	client.record(b, CommandTimedOut{Limit: time.Second})
+	cooled(client)
+	if ok, _ := client.admit(b); !ok {
+		t.Fatal(`trial not admitted`)
+	}
+
+	// a canceled trial frees the slot without closing or reopening
+	client.release()
+	checkCircuit(t, client, b, BreakerHalfOpen, 1, true)
+}
+
+func TestBreaker_disabled(t *testing.T) {
+	client := new(Client)
+	refused := &net.OpError{Op: `dial`, Net: `tcp`, Err: errors.New(`refused`)}
+	for _, b := range []*Breaker{nil, {}} {
+		for i := 0; i < 5; i++ {
+			client.record(b, refused)
+		}
+		ok, _ := client.admit(b)
+		checkCircuit(t, client, b, BreakerClosed, 0, true)
+		if !ok {
+			t.Fatal(`not admitted`)
+		}
+	}
+}
+
+func TestController_completed(t *testing.T) {
+	ctl := &Controller{Breaker: &Breaker{Threshold: 1, Cooldown: time.Minute}}
+	client := new(Client)
+	client.record(ctl.Breaker, CommandTimedOut{Limit: time.Second})
+	cooled(client)
+	client.admit(ctl.Breaker)
+
+	// a command that never started for reasons of its own neither
+	// closes nor reopens the circuit but frees the trial
+	ctl.completed(notStarted(client, errors.New(`invalid environment variable name`)), time.Now())
+	checkCircuit(t, client, ctl.Breaker, BreakerHalfOpen, 1, true)
+
+	// one that ran to completion closes it
+	client.admit(ctl.Breaker)
+	ctl.completed(&Result{Client: client}, time.Now())
+	checkCircuit(t, client, ctl.Breaker, BreakerClosed, 0, true)
+}
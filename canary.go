@@ -0,0 +1,176 @@
+package ssh
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// CanaryAborted is returned when the fleet phase of a canary run is not
+// started because the canary phase failed or was not confirmed (see
+// [Controller.RunCanary]).
+type CanaryAborted struct {
+	Reason string
+}
+
+func (e CanaryAborted) Error() string { return `canary aborted: ` + e.Reason }
+
+// PhaseFailed is returned when commands fail the checks of the fleet
+// phase of a canary run (see [Controller.RunCanary]).
+type PhaseFailed struct {
+	Phase  string
+	Failed int
+	Total  int
+}
+
+func (e PhaseFailed) Error() string {
+	return fmt.Sprintf(`%v phase: %v of %v failed`, e.Phase, e.Failed, e.Total)
+}
+
+// ------------------------------ Canary ------------------------------
+
+// Canary contains the settings of a canary run (see
+// [Controller.RunCanary]). A command passes its checks when it exits
+// with ExitCode, its standard output matches the Output regular
+// expression (if any), and Check (if any) returns nil.
+type Canary struct {
+
+	// Count is the number of canary clients. If unset 1 is used.
+	Count int
+
+	// ExitCode is the expected exit status of the command.
+	ExitCode int
+
+	// Output is a regular expression (see [regexp.Compile]) that
+	// standard output must match (optional).
+	Output string
+
+	// Check is called with the Result of each command after the other
+	// checks pass and returns the reason it failed (optional).
+	Check func(r *Result) error
+
+	// Confirm is called with the report of the canary phase once it has
+	// passed and must return true for the rest of the fleet to be run
+	// (optional). It may block as long as needed (to ask a person to
+	// confirm for example).
+	Confirm func(report *CanaryReport) bool
+}
+
+// Phase is the report of a single phase of a canary run. Results and
+// Errors are in the same order and an Error is nil if the command
+// passed the checks of the Canary.
+type Phase struct {
+	Name     string
+	Start    time.Time
+	Duration time.Duration
+	Results  []*Result
+	Errors   []error
+	Passed   int
+	Failed   int
+}
+
+// CanaryReport is the report of a canary run with the canary phase and,
+// if it passed and was confirmed, the fleet phase (which is nil if
+// never started). Err is the same error returned by RunCanary.
+type CanaryReport struct {
+	Canary    *Phase
+	Confirmed bool
+	Fleet     *Phase
+	Err       error
+}
+
+// RunCanary runs cmd with optional standard input on Count canary
+// clients chosen by the Selector (see [Controller.Selector]) first and,
+// only if all of them pass the checks of the Canary and Confirm (if
+// set) returns true, then on every other client in the Clients list
+// (like [Controller.RunOnAll]). The commands of each phase are run
+// concurrently. Returns the report of every phase run (which is never
+// nil) along with [AllUnavailable] if no canary could be chosen,
+// [CanaryAborted] if the fleet phase was not started, or [PhaseFailed]
+// if any command of the fleet phase failed the checks.
+func (c *Controller) RunCanary(canary Canary, cmd string, stdin []byte, opts ...RunOption) (*CanaryReport, error) {
+	report := new(CanaryReport)
+	fail := func(err error) (*CanaryReport, error) {
+		report.Err = err
+		return report, err
+	}
+	var output *regexp.Regexp
+	if len(canary.Output) > 0 {
+		var err error
+		if output, err = regexp.Compile(canary.Output); err != nil {
+			return fail(err)
+		}
+	}
+	count := canary.Count
+	if count <= 0 {
+		count = 1
+	}
+	opts = c.options(opts)
+	chosen := map[*Client]bool{}
+	var canaries []*Client
+	for len(canaries) < count {
+		client := c.selectClient(chosen, nil)
+		if client == nil {
+			break
+		}
+		chosen[client] = true
+		canaries = append(canaries, client)
+	}
+	if len(canaries) == 0 {
+		return fail(AllUnavailable{})
+	}
+	report.Canary = c.phase(`canary`, canaries, canary, output, cmd, stdin, opts)
+	if report.Canary.Failed > 0 {
+		return fail(CanaryAborted{fmt.Sprintf(`%v of %v canaries failed`,
+			report.Canary.Failed, len(canaries))})
+	}
+	if canary.Confirm != nil && !canary.Confirm(report) {
+		return fail(CanaryAborted{`not confirmed`})
+	}
+	report.Confirmed = true
+	var fleet []*Client
+	for _, client := range c.Clients {
+		if !chosen[client] {
+			fleet = append(fleet, client)
+		}
+	}
+	report.Fleet = c.phase(`fleet`, fleet, canary, output, cmd, stdin, opts)
+	if report.Fleet.Failed > 0 {
+		return fail(PhaseFailed{`fleet`, report.Fleet.Failed, len(fleet)})
+	}
+	return report, nil
+}
+
+// phase runs the command on all clients and checks every Result.
+func (c *Controller) phase(name string, clients []*Client, canary Canary, output *regexp.Regexp, cmd string, stdin []byte, opts []RunOption) *Phase {
+	p := &Phase{Name: name, Start: time.Now()}
+	p.Results = c.runAll(clients, cmd, stdin, opts)
+	p.Duration = time.Since(p.Start)
+	for _, r := range p.Results {
+		err := expect(r, canary.ExitCode, output)
+		if err == nil && canary.Check != nil {
+			err = canary.Check(r)
+		}
+		p.Errors = append(p.Errors, err)
+		if err != nil {
+			p.Failed++
+		} else {
+			p.Passed++
+		}
+	}
+	return p
+}
+
+// expect returns the reason r does not have the expected exit status
+// or output (if output is not nil) or nil if it does.
+func expect(r *Result, code int, output *regexp.Regexp) error {
+	switch {
+	case r.ExitCode == -1 && r.Err != nil:
+		return r.Err
+	case r.ExitCode != code:
+		return fmt.Errorf(`exited with %v (expected %v)`, r.ExitCode, code)
+	case output != nil && !output.MatchString(r.Stdout):
+		return fmt.Errorf(`output does not match %q`, output)
+	}
+	return nil
+}
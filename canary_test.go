@@ -0,0 +1,104 @@
+package ssh
+
+import (
+	"errors"
+	"io"
+	"regexp"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// first selects the first candidate so tests know the canaries.
+type first struct{}
+
+func (first) Select(candidates []*Client, key string) *Client { return candidates[0] }
+
+func TestExpect(t *testing.T) {
+	ok := regexp.MustCompile(`^ok`)
+	tests := []struct {
+		name   string
+		r      *Result
+		code   int
+		output *regexp.Regexp
+		pass   bool
+	}{
+		{`passes`, &Result{Stdout: `ok`}, 0, ok, true},
+		{`no output check`, &Result{Stdout: `nope`}, 0, nil, true},
+		{`expected non-zero`, &Result{ExitCode: 3, Err: &ssh.ExitError{}}, 3, nil, true},
+		{`wrong code`, &Result{ExitCode: 1, Err: &ssh.ExitError{}}, 0, nil, false},
+		{`wrong output`, &Result{Stdout: `nope`}, 0, ok, false},
+		{`not run`, notStarted(nil, io.EOF), -1, nil, false},
+	}
+	for _, tt := range tests {
+		if err := expect(tt.r, tt.code, tt.output); (err == nil) != tt.pass {
+			t.Errorf(`%v: got %v`, tt.name, err)
+		}
+	}
+}
+
+func TestController_RunCanary(t *testing.T) {
+	good := newTestServer(t, "ok\n", 0)
+	bad := newTestServer(t, "ok\n", 1)
+	fleet := newTestServer(t, "ok\n", 0)
+	failing := newTestServer(t, "ok\n", 2)
+	errBad := errors.New(`bad`)
+	aborted := func(err error) bool { return errors.As(err, new(CanaryAborted)) }
+
+	tests := []struct {
+		name     string
+		canary   *testServer
+		fleet    *testServer
+		settings Canary
+		check    func(err error) bool
+		fleetRan int
+	}{
+		{`passes`, good, fleet, Canary{Output: `^ok`}, func(err error) bool { return err == nil }, 1},
+		{`canary fails`, bad, fleet, Canary{}, aborted, 0},
+		{`expected exit code`, bad, failing, Canary{ExitCode: 1}, func(err error) bool {
+			return err == (PhaseFailed{`fleet`, 1, 1})
+		}, 1},
+		{`output does not match`, good, fleet, Canary{Output: `^fine`}, aborted, 0},
+		{`check fails`, good, fleet, Canary{Check: func(*Result) error { return errBad }}, aborted, 0},
+		{`not confirmed`, good, fleet, Canary{Confirm: func(r *CanaryReport) bool { return false }}, aborted, 0},
+		{`confirmed`, good, fleet, Canary{Confirm: func(r *CanaryReport) bool { return r.Canary.Passed == 1 }},
+			func(err error) bool { return err == nil }, 1},
+		{`fleet fails`, good, failing, Canary{}, func(err error) bool {
+			return err == (PhaseFailed{`fleet`, 1, 1})
+		}, 1},
+		{`invalid output`, good, fleet, Canary{Output: `(`}, func(err error) bool {
+			return err != nil && !aborted(err)
+		}, 0},
+	}
+	for _, tt := range tests {
+		canaryRan, fleetRan := tt.canary.Ran(), tt.fleet.Ran()
+		ctl := &Controller{Selector: first{}}
+		ctl.Init(tt.canary.Client(t), tt.fleet.Client(t))
+		report, err := ctl.RunCanary(tt.settings, `check`, nil)
+		if !tt.check(err) || report.Err != err {
+			t.Errorf(`%v: unexpected error %v`, tt.name, err)
+			continue
+		}
+		if ran := tt.fleet.Ran() - fleetRan; ran != tt.fleetRan {
+			t.Errorf(`%v: fleet ran %v times want %v`, tt.name, ran, tt.fleetRan)
+		}
+		if tt.fleetRan == 0 {
+			if report.Fleet != nil || report.Confirmed {
+				t.Errorf(`%v: fleet phase reported %+v %v`, tt.name, report.Fleet, report.Confirmed)
+			}
+			continue
+		}
+		if tt.canary.Ran()-canaryRan != 1 || report.Canary.Passed != 1 || !report.Confirmed ||
+			len(report.Fleet.Results) != 1 {
+			t.Errorf(`%v: got %+v`, tt.name, report)
+		}
+	}
+}
+
+func TestController_RunCanary_unavailable(t *testing.T) {
+	ctl := new(Controller).Init(&Client{})
+	report, err := ctl.RunCanary(Canary{}, `check`, nil)
+	if err != (AllUnavailable{}) || report.Canary != nil || report.Fleet != nil {
+		t.Fatal(report, err)
+	}
+}
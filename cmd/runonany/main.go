@@ -5,7 +5,6 @@ import (
 	"io"
 	"log"
 	"os"
-	"strings"
 
 	"github.com/rwxrob/ssh"
 	"gopkg.in/yaml.v3"
@@ -19,7 +18,7 @@ func main() {
 	}
 
 	// read the command and standard input to the binary
-	cmdline := strings.Join(os.Args[1:], ` `)
+	cmdline := ssh.POSIX.Join(os.Args[1:]...)
 	stdin, err := io.ReadAll(os.Stdin)
 	if err != nil {
 		log.Fatal(err)
@@ -0,0 +1,383 @@
+package ssh
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultKillGrace is how long [Command.Kill] waits for the remote
+// command to exit after sending a KILL signal before falling back to
+// closing the session.
+var DefaultKillGrace = 2 * time.Second
+
+// Signal is a POSIX signal name (without the SIG prefix) that can be
+// sent to a running remote command with [Command.Signal].
+type Signal = ssh.Signal
+
+// Signals defined by RFC 4254 section 6.10.
+const (
+	SIGABRT = ssh.SIGABRT
+	SIGALRM = ssh.SIGALRM
+	SIGFPE  = ssh.SIGFPE
+	SIGHUP  = ssh.SIGHUP
+	SIGILL  = ssh.SIGILL
+	SIGINT  = ssh.SIGINT
+	SIGKILL = ssh.SIGKILL
+	SIGPIPE = ssh.SIGPIPE
+	SIGQUIT = ssh.SIGQUIT
+	SIGSEGV = ssh.SIGSEGV
+	SIGTERM = ssh.SIGTERM
+	SIGUSR1 = ssh.SIGUSR1
+	SIGUSR2 = ssh.SIGUSR2
+)
+
+// WithPID records the process ID of the shell running the command on
+// the target host (see [Command.PID]) so that [Command.Kill] can kill
+// it with pkill and kill when the server ignores signal requests (as
+// OpenSSH before 7.9 does). The PID is printed on the first line of
+// standard output by the shell before the command runs and removed
+// from the captured output. Only supported for the POSIX dialect and
+// not with [WithBecome] (since the login user could not kill the
+// escalated process).
+func WithPID() RunOption {
+	return func(c *runConfig) { c.pid = true }
+}
+
+// ------------------------------ Result ------------------------------
+
+// Result contains everything known about a completed command.
+type Result struct {
+
+	// Client on which the command was run (if any).
+	Client *Client
+
+	// Stdout and Stderr contain the captured output of the command
+	// (which may be truncated, see [WithMaxOutput]).
+	Stdout string
+	Stderr string
+
+	// StdoutTruncated and StderrTruncated are true if some of the
+	// output was discarded to stay within the maximum size.
+	StdoutTruncated bool
+	StderrTruncated bool
+
+	// Chunks contains all output in the order received when recorded
+	// (see [WithCombined]) and ChunksTruncated is true if any of it was
+	// discarded to stay within the maximum size.
+	Chunks          []Chunk
+	ChunksTruncated bool
+
+	// ExitCode is the exit status of the command, which is -1 if it did
+	// not exit normally (killed by a signal, lost connection, etc.) or
+	// never ran.
+	ExitCode int
+
+	// Attempts contains every attempt made to run the command (including
+	// the one this Result is for) when run on any client (see
+	// [Controller.ExecOnAny]).
+	Attempts []Attempt
+
+	// Err is the error (if any) returned with the Result.
+	Err error
+
+	unstarted bool // the command was never started
+}
+
+// notStarted returns the Result of a command that could not be started
+// on client because of err.
+func notStarted(client *Client, err error) *Result {
+	return &Result{Client: client, ExitCode: -1, Err: err, unstarted: true}
+}
+
+// Combined returns the data of all Chunks joined in order, which is the
+// standard output and error interleaved as they were received. Returns
+// an empty string unless output was recorded with [WithCombined].
+func (r *Result) Combined() string {
+	var b strings.Builder
+	for _, c := range r.Chunks {
+		b.WriteString(c.Data)
+	}
+	return b.String()
+}
+
+// Exec is the same as [Client.Run] but returns the complete Result of
+// the command, which is never nil (even when an error is returned).
+func (c *Client) Exec(cmd string, stdin []byte, opts ...RunOption) (*Result, error) {
+	x, err := c.Start(cmd, stdin, opts...)
+	if err != nil {
+		return notStarted(c, err), err
+	}
+	r := x.Result()
+	return r, r.Err
+}
+
+// ------------------------------ Command -----------------------------
+
+// Command is a handle to a command running on the target host started
+// with [Client.Start]. Its standard output and error are captured as
+// they arrive and can be read at any time.
+type Command struct {
+	client *Client
+	sess   *ssh.Session
+	stdout *buffer
+	stderr *buffer
+	chunks *recorder
+	pid    *pidWriter
+	become *becomer
+	done   chan struct{}
+	err    error
+
+	timeout  time.Duration
+	timedout atomic.Bool
+}
+
+// Start starts cmd with optional standard input on the target host as
+// a new ssh.Session and returns without waiting for it to complete.
+// Connecting, errors, and run options are handled exactly as with
+// [Client.Run] (which is Start followed by [Command.Wait]). If
+// a command timeout applies (see [WithTimeout]) the command is killed
+// (see [Command.Kill]) once it expires and Wait returns
+// a [CommandTimedOut] error. If the client already has MaxSessions
+// open Start waits for one to close first (see [Client.Queued]).
+func (c *Client) Start(cmd string, stdin []byte, opts ...RunOption) (*Command, error) {
+	cfg := newRunConfig(opts)
+	if cfg.template {
+		var err error
+		cmd, err = c.Render(cmd, cfg.vars)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if cfg.script != nil {
+		cmd = cfg.script.command(c.Dialect)
+	}
+	release := c.acquire()
+	started := false
+	defer func() {
+		if !started {
+			release()
+		}
+	}()
+	client, err := c.connection()
+	if err != nil {
+		return nil, err
+	}
+	sess, err := client.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	cmd, err = cfg.prepare(sess.Setenv, cmd, c.Dialect)
+	if err != nil {
+		sess.Close()
+		return nil, err
+	}
+	x := &Command{
+		client: c,
+		sess:   sess,
+		stdout: newBuffer(cfg.outputLimit()),
+		stderr: newBuffer(cfg.outputLimit()),
+		done:   make(chan struct{}),
+	}
+	if len(stdin) > 0 && cfg.become == nil {
+		sess.Stdin = bytes.NewReader(stdin)
+	}
+	sess.Stdout = x.stdout
+	sess.Stderr = x.stderr
+	if cfg.combined {
+		x.chunks = newRecorder(cfg.outputLimit())
+		sess.Stdout = io.MultiWriter(x.stdout, x.chunks.writer(StreamStdout))
+		sess.Stderr = io.MultiWriter(x.stderr, x.chunks.writer(StreamStderr))
+	}
+	if cfg.pid {
+		if c.Dialect != POSIX {
+			sess.Close()
+			return nil, fmt.Errorf(`recording PID not supported for dialect: %v`, c.Dialect)
+		}
+		if cfg.become != nil {
+			sess.Close()
+			return nil, fmt.Errorf(`recording PID not supported with become`)
+		}
+		x.pid = &pidWriter{next: sess.Stdout}
+		sess.Stdout = x.pid
+		cmd = `echo $$; ` + cmd
+	}
+	if cfg.become != nil {
+		if x.become, err = x.escalate(cmd, stdin, cfg.become); err != nil {
+			sess.Close()
+			return nil, err
+		}
+		cmd = x.become.cmd
+	}
+	if err := sess.Start(cmd); err != nil {
+		sess.Close()
+		return nil, err
+	}
+	started = true
+	start := time.Now()
+	c.inflight.Add(1)
+	var timer *time.Timer
+	if x.timeout = cfg.commandTimeout(c); x.timeout > 0 {
+		timer = time.AfterFunc(x.timeout, func() {
+			x.timedout.Store(true)
+			x.Kill()
+		})
+	}
+	go func() {
+		x.err = sess.Wait()
+		c.inflight.Add(-1)
+		c.observe(time.Since(start))
+		if timer != nil {
+			timer.Stop()
+		}
+		if x.timedout.Load() {
+			x.err = CommandTimedOut{c.Dest(), x.timeout}
+		} else if x.become != nil {
+			if err := x.become.err(); err != nil {
+				x.err = err
+			}
+		}
+		sess.Close()
+		release()
+		close(x.done)
+	}()
+	return x, nil
+}
+
+// Client returns the client that started the command.
+func (x *Command) Client() *Client { return x.client }
+
+// Stdout returns the standard output captured so far.
+func (x *Command) Stdout() string { return x.stdout.String() }
+
+// Stderr returns the standard error captured so far.
+func (x *Command) Stderr() string { return x.stderr.String() }
+
+// PID returns the process ID recorded with [WithPID] or 0 if not (yet)
+// known.
+func (x *Command) PID() int {
+	if x.pid == nil {
+		return 0
+	}
+	return x.pid.get()
+}
+
+// Result waits for the command to complete and returns its Result.
+func (x *Command) Result() *Result {
+	err := x.Wait()
+	r := &Result{
+		Client:          x.client,
+		Stdout:          x.stdout.String(),
+		Stderr:          x.stderr.String(),
+		StdoutTruncated: x.stdout.Truncated(),
+		StderrTruncated: x.stderr.Truncated(),
+		Err:             err,
+	}
+	var exit *ssh.ExitError
+	switch {
+	case errors.As(err, &exit):
+		r.ExitCode = exit.ExitStatus()
+	case err != nil:
+		r.ExitCode = -1
+	}
+	if x.chunks != nil {
+		r.Chunks, r.ChunksTruncated = x.chunks.chunks()
+	}
+	return r
+}
+
+// Done returns a channel that is closed once the command has completed
+// and the session closed.
+func (x *Command) Done() <-chan struct{} { return x.done }
+
+// Wait waits for the command to complete and returns the error (if
+// any) from [ssh.Session.Wait], which is an [ssh.ExitError] if the
+// command exits with a non-zero status or because of a signal.
+func (x *Command) Wait() error {
+	<-x.done
+	return x.err
+}
+
+// Signal sends sig to the remote command with an SSH signal request.
+// Note that many servers (including OpenSSH before 7.9) silently
+// ignore signal requests (see [Command.Kill]).
+func (x *Command) Signal(sig Signal) error { return x.sess.Signal(sig) }
+
+// Kill sends a KILL signal to the remote command and waits up to
+// DefaultKillGrace for it to exit. If it has not, the server is assumed
+// to be ignoring signals and the session is closed instead, which
+// unblocks [Command.Wait] but may leave the command running on the
+// target host. When a PID was recorded (see [WithPID]) its child
+// processes and then the shell itself are also killed with pkill and
+// kill from a new session. Returns nil if the command has already
+// completed.
+func (x *Command) Kill() error {
+	select {
+	case <-x.done:
+		return nil
+	default:
+	}
+	x.sess.Signal(SIGKILL)
+	select {
+	case <-x.done:
+		return nil
+	case <-time.After(DefaultKillGrace):
+	}
+	x.sess.Close()
+	if pid := x.PID(); pid > 0 {
+		p := strconv.Itoa(pid)
+		_, _, err := x.client.Run(`pkill -KILL -P `+p+`; kill -KILL `+p, nil)
+		if _, is := err.(*ssh.ExitError); !is {
+			return err
+		}
+	}
+	return nil
+}
+
+// pidWriter consumes the first line written to it as a process ID and
+// passes everything after it through to next.
+type pidWriter struct {
+	mu   sync.Mutex
+	next io.Writer
+	line []byte
+	pid  int
+	read bool
+}
+
+func (w *pidWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.read {
+		return w.next.Write(p)
+	}
+	n := len(p)
+	i := bytes.IndexByte(p, '\n')
+	if i < 0 {
+		w.line = append(w.line, p...)
+		return n, nil
+	}
+	w.line = append(w.line, p[:i]...)
+	w.pid, _ = strconv.Atoi(string(bytes.TrimSpace(w.line)))
+	w.read = true
+	if i+1 == n {
+		return n, nil
+	}
+	if _, err := w.next.Write(p[i+1:]); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (w *pidWriter) get() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.pid
+}
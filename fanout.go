@@ -0,0 +1,33 @@
+package ssh
+
+import "sync"
+
+// ------------------------------ Fan-out ------------------------------
+
+// RunOnAll runs cmd with optional standard input concurrently on every
+// client in the Clients list with [Client.Exec] and returns all the
+// results in the same order once every one has completed (see
+// [Controller.CommandTimeout] to keep a single stuck client from
+// stalling them all). Clients that have not yet connected are connected
+// first and any that fail have the error in their Result. Use
+// [WithTemplate] to tailor the command for each client.
+func (c *Controller) RunOnAll(cmd string, stdin []byte, opts ...RunOption) []*Result {
+	return c.runAll(c.Clients, cmd, stdin, c.options(opts))
+}
+
+// runAll runs the command concurrently on all clients with opts (which
+// already include the defaults of the Controller).
+func (c *Controller) runAll(clients []*Client, cmd string, stdin []byte, opts []RunOption) []*Result {
+	results := make([]*Result, len(clients))
+	var wg sync.WaitGroup
+	for i, client := range clients {
+		wg.Add(1)
+		go func(i int, client *Client) {
+			defer wg.Done()
+			results[i], _ = client.Exec(cmd, stdin, opts...)
+			c.record(client, results[i].Err)
+		}(i, client)
+	}
+	wg.Wait()
+	return results
+}
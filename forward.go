@@ -0,0 +1,375 @@
+package ssh
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ----------------------------- Forward ------------------------------
+
+// ForwardStats is a snapshot of the connection and byte counters of
+// a Forward at the time [Forward.Stats] was called.
+type ForwardStats struct {
+	Accepted int64 // connections accepted on the listening side
+	Active   int64 // connections currently being piped
+	Failed   int64 // connections that could not be dialed
+	Sent     int64 // bytes sent from the listening to the dialed side
+	Received int64 // bytes received from the dialed to the listening side
+}
+
+// Forward is a handle to a running port forward created by one of the
+// forwarding methods of Client (see [Client.ForwardLocal]). Every
+// connection accepted by the listener is piped to a new connection
+// created with the forward's dial function (which is passed the accepted
+// connection) until [Forward.Close] is called.
+type Forward struct {
+	listener net.Listener
+	dial     func(net.Conn) (net.Conn, error)
+
+	accepted atomic.Int64
+	active   atomic.Int64
+	failed   atomic.Int64
+	sent     atomic.Int64
+	received atomic.Int64
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+	wg    sync.WaitGroup
+	quit  chan struct{}
+	done  chan struct{}
+	once  sync.Once
+	err   error
+}
+
+func newForward(l net.Listener, dial func(net.Conn) (net.Conn, error)) *Forward {
+	f := &Forward{
+		listener: l,
+		dial:     dial,
+		conns:    map[net.Conn]struct{}{},
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	f.wg.Add(1)
+	go f.serve()
+	return f
+}
+
+// Addr returns the address of the listening side of the forward. This
+// is useful when binding to port 0 to discover the port assigned.
+func (f *Forward) Addr() net.Addr { return f.listener.Addr() }
+
+// Stats returns a snapshot of the counters of the forward.
+func (f *Forward) Stats() ForwardStats {
+	return ForwardStats{
+		Accepted: f.accepted.Load(),
+		Active:   f.active.Load(),
+		Failed:   f.failed.Load(),
+		Sent:     f.sent.Load(),
+		Received: f.received.Load(),
+	}
+}
+
+// Done returns a channel that is closed when the forward stops
+// accepting connections for any reason (see [Forward.Err]).
+func (f *Forward) Done() <-chan struct{} { return f.done }
+
+// Err returns the error that caused the forward to stop accepting
+// connections, or nil if it is still running or was stopped by Close.
+func (f *Forward) Err() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.err
+}
+
+// Close stops accepting new connections, closes all active ones, and
+// waits for all of them to finish before returning.
+func (f *Forward) Close() error {
+	var err error
+	f.once.Do(func() {
+		f.mu.Lock()
+		close(f.quit)
+		f.mu.Unlock()
+		err = f.listener.Close()
+		f.mu.Lock()
+		for conn := range f.conns {
+			conn.Close()
+		}
+		f.mu.Unlock()
+	})
+	f.wg.Wait()
+	return err
+}
+
+func (f *Forward) serve() {
+	defer f.wg.Done()
+	defer close(f.done)
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			f.mu.Lock()
+			select {
+			case <-f.quit:
+			default:
+				f.err = err
+			}
+			f.mu.Unlock()
+			return
+		}
+		f.accepted.Add(1)
+		f.wg.Add(1)
+		go f.handle(conn)
+	}
+}
+
+func (f *Forward) track(conn net.Conn) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	select {
+	case <-f.quit:
+		return false
+	default:
+	}
+	f.conns[conn] = struct{}{}
+	return true
+}
+
+func (f *Forward) untrack(conn net.Conn) {
+	f.mu.Lock()
+	delete(f.conns, conn)
+	f.mu.Unlock()
+}
+
+func (f *Forward) handle(local net.Conn) {
+	defer f.wg.Done()
+	defer local.Close()
+	if !f.track(local) {
+		return
+	}
+	defer f.untrack(local)
+	remote, err := f.dial(local)
+	if err != nil {
+		f.failed.Add(1)
+		return
+	}
+	defer remote.Close()
+	if !f.track(remote) {
+		return
+	}
+	defer f.untrack(remote)
+	f.active.Add(1)
+	defer f.active.Add(-1)
+	pipe(local, remote, &f.sent, &f.received)
+}
+
+// pipe copies data in both directions between a and b until either
+// side is done, counting the bytes sent from a to b and received from
+// b to a.
+func pipe(a, b net.Conn, sent, received *atomic.Int64) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	cp := func(dst, src net.Conn, count *atomic.Int64) {
+		defer wg.Done()
+		n, _ := io.Copy(dst, src)
+		count.Add(n)
+		if cw, is := dst.(interface{ CloseWrite() error }); is {
+			cw.CloseWrite()
+		} else {
+			dst.Close()
+		}
+	}
+	go cp(b, a, sent)
+	go cp(a, b, received)
+	wg.Wait()
+}
+
+// ------------------------- Local Forwarding -------------------------
+
+// ForwardLocal is the equivalent of ssh -L. It listens on the local
+// laddr (lnet "tcp" or "unix") and forwards every accepted connection
+// through the SSH connection of the Client to raddr (rnet "tcp" or
+// "unix") as seen from the target host (see [Client.Dial]). If the
+// Client has not yet connected [Client.Connect] is called first. Each
+// forwarded connection is dialed with the internal ssh.Client current
+// at the time it is accepted so that the forward keeps working after
+// the Client reconnects. When dialing fails because the SSH connection
+// has gone away the Client is reconnected automatically and the dial
+// attempted once more.
+func (c *Client) ForwardLocal(lnet, laddr, rnet, raddr string) (*Forward, error) {
+	if err := checkNetwork(rnet); err != nil {
+		return nil, err
+	}
+	if _, err := c.connection(); err != nil {
+		return nil, err
+	}
+	l, err := net.Listen(lnet, laddr)
+	if err != nil {
+		return nil, err
+	}
+	return newForward(l, func(net.Conn) (net.Conn, error) {
+		return c.Dial(rnet, raddr)
+	}), nil
+}
+
+// checkNetwork returns an error unless network is one that can be
+// dialed or listened on from the target host.
+func checkNetwork(network string) error {
+	switch network {
+	case `tcp`, `tcp4`, `tcp6`, `unix`:
+		return nil
+	}
+	return fmt.Errorf(`unsupported remote network: %v`, network)
+}
+
+// Dial opens a new connection from the target host to addr through the
+// SSH connection of the Client as a direct-tcpip channel (network
+// "tcp") or as a direct-streamlocal@openssh.com channel to a Unix
+// domain socket path on the target host (network "unix"). The returned
+// connection can be used anywhere a net.Conn is expected (for example,
+// the DialContext of an http.Transport talking to a remote
+// /var/run/docker.sock). If the Client has not yet connected, or the
+// SSH connection is no longer alive, [Client.Connect] is called first.
+func (c *Client) Dial(network, addr string) (net.Conn, error) {
+	if err := checkNetwork(network); err != nil {
+		return nil, err
+	}
+	client := c.SSHClient()
+	if client != nil {
+		conn, err := client.Dial(network, addr)
+		if err == nil || alive(client) {
+			return conn, err
+		}
+	}
+	client, err := c.reconnect(client)
+	if err != nil {
+		return nil, err
+	}
+	return client.Dial(network, addr)
+}
+
+// reconnect calls Connect unless the internal ssh.Client has already
+// been replaced with a different one than stale (by another goroutine
+// or a direct call to Connect) and returns the current one.
+func (c *Client) reconnect(stale *ssh.Client) (*ssh.Client, error) {
+	c.reconnecting.Lock()
+	defer c.reconnecting.Unlock()
+	if current := c.SSHClient(); current != nil && current != stale {
+		return current, nil
+	}
+	if stale != nil {
+		stale.Close()
+	}
+	if err := c.Connect(); err != nil {
+		return nil, err
+	}
+	return c.SSHClient(), nil
+}
+
+// connection returns the internal ssh.Client calling Connect first (see
+// [Client.reconnect]) if there is none, which is never nil unless an
+// error is returned.
+func (c *Client) connection() (*ssh.Client, error) {
+	if client := c.SSHClient(); client != nil {
+		return client, nil
+	}
+	return c.reconnect(nil)
+}
+
+// alive sends an OpenSSH keepalive request to the server and reports
+// whether the connection is still responding.
+func alive(client *ssh.Client) bool {
+	_, _, err := client.SendRequest(`keepalive@openssh.com`, true, nil)
+	return err == nil
+}
+
+// ------------------------ Remote Forwarding -------------------------
+
+// ForwardRemote is the equivalent of ssh -R. It asks the SSH server to
+// listen on raddr (rnet "tcp") of the target host with a tcpip-forward
+// request, or on a Unix domain socket path (rnet "unix") with
+// a streamlocal-forward@openssh.com request, and pipes every channel
+// forwarded back for it to a new connection dialed locally to laddr
+// (lnet "tcp" or "unix"). A port of 0 in raddr lets the server choose
+// one which can then be obtained from [Forward.Addr]. Closing the
+// forward sends the matching cancel request to the server (which
+// usually leaves any remote socket file in place). If the Client has not
+// yet connected [Client.Connect] is called first. Unlike
+// [Client.ForwardLocal] the remote listener belongs to the SSH
+// connection and stops (see [Forward.Done]) if it goes away.
+func (c *Client) ForwardRemote(rnet, raddr, lnet, laddr string) (*Forward, error) {
+	if err := checkNetwork(rnet); err != nil {
+		return nil, err
+	}
+	client, err := c.connection()
+	if err != nil {
+		return nil, err
+	}
+	l, err := client.Listen(rnet, raddr)
+	if err != nil {
+		return nil, err
+	}
+	return newForward(l, func(net.Conn) (net.Conn, error) {
+		return net.Dial(lnet, laddr)
+	}), nil
+}
+
+// ------------------------- Controller Tunnel -------------------------
+
+// Tunnel is a load-balanced equivalent of ssh -L for the whole
+// Controller. It listens on the local laddr (lnet "tcp" or "unix") and
+// forwards each accepted connection through a connected client chosen
+// by the Selector (see [Controller.Selector]) to raddr (rnet "tcp" or
+// "unix") as seen from that client's target host, which is useful for
+// reaching a service replicated on every host. When dialing through the
+// selected client fails it is marked as no longer [Client.Connected],
+// reconnected in a separate goroutine (as [Controller.RunOnAny] does),
+// and the next client attempted until none remain. Connections already
+// established are not moved when their client drops.
+func (c *Controller) Tunnel(lnet, laddr, rnet, raddr string) (*Forward, error) {
+	if err := checkNetwork(rnet); err != nil {
+		return nil, err
+	}
+	l, err := net.Listen(lnet, laddr)
+	if err != nil {
+		return nil, err
+	}
+	return newForward(l, func(net.Conn) (net.Conn, error) {
+		return c.dialAny(rnet, raddr)
+	}), nil
+}
+
+// dialAny dials addr through a connected client chosen by the Selector
+// failing over to the others in turn. Failing to dial addr when the
+// connection of the client is still alive does not count as a failure
+// of the client for its circuit breaker.
+func (c *Controller) dialAny(network, addr string) (net.Conn, error) {
+	tried := map[*Client]bool{}
+	for {
+		client := c.selectClient(tried, nil)
+		if client == nil {
+			return nil, AllUnavailable{}
+		}
+		tried[client] = true
+		sshclient := client.SSHClient()
+		if sshclient == nil {
+			client.release()
+			continue
+		}
+		conn, err := sshclient.Dial(network, addr)
+		if err == nil || alive(sshclient) {
+			// only failures of the SSH connection itself count for the
+			// breaker and not those of the remote addr
+			c.record(client, nil)
+			if err == nil {
+				return conn, nil
+			}
+			continue
+		}
+		client.disconnected()
+		c.record(client, err)
+	}
+}
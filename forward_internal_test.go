@@ -0,0 +1,186 @@
+package ssh
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// echo starts a local TCP server that writes back everything prefixed
+// with name and returns its address.
+func echo(t *testing.T, name string) string {
+	t.Helper()
+	l, err := net.Listen(`tcp`, `127.0.0.1:0`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				buf := make([]byte, 256)
+				for {
+					n, err := conn.Read(buf)
+					if err != nil {
+						return
+					}
+					conn.Write(append([]byte(name), buf[:n]...))
+				}
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func roundtrip(t *testing.T, addr, msg string) (net.Conn, string) {
+	t.Helper()
+	conn, err := net.Dial(`tcp`, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+	if _, err := conn.Write([]byte(msg)); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 256)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, string(buf[:n])
+}
+
+// eventually waits for cond to become true and fails the test if it
+// does not in time.
+func eventually(t *testing.T, cond func() bool) {
+	t.Helper()
+	for i := 0; i < 200; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal(`condition not met in time`)
+}
+
+func newTestForward(t *testing.T, dial func(net.Conn) (net.Conn, error)) *Forward {
+	t.Helper()
+	l, err := net.Listen(`tcp`, `127.0.0.1:0`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := newForward(l, dial)
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func TestForward_pipe(t *testing.T) {
+	backend := echo(t, ``)
+	f := newTestForward(t, func(net.Conn) (net.Conn, error) {
+		return net.Dial(`tcp`, backend)
+	})
+	conn, got := roundtrip(t, f.Addr().String(), `hello`)
+	if got != `hello` {
+		t.Fatalf(`got %q`, got)
+	}
+	if s := f.Stats(); s.Accepted != 1 || s.Active != 1 {
+		t.Fatalf(`%+v`, s)
+	}
+	conn.Close()
+	eventually(t, func() bool { return f.Stats().Active == 0 })
+	want := ForwardStats{Accepted: 1, Sent: 5, Received: 5}
+	if s := f.Stats(); s != want {
+		t.Fatalf(`got %+v want %+v`, s, want)
+	}
+}
+
+func TestForward_failed(t *testing.T) {
+	f := newTestForward(t, func(net.Conn) (net.Conn, error) {
+		return nil, errors.New(`unreachable`)
+	})
+	conn, err := net.Dial(`tcp`, f.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf(`expected closed connection, got %v`, err)
+	}
+	eventually(t, func() bool { return f.Stats().Failed == 1 })
+	if s := f.Stats(); s.Accepted != 1 || s.Active != 0 {
+		t.Fatalf(`%+v`, s)
+	}
+}
+
+func TestForward_redial(t *testing.T) {
+
+	// every accepted connection is dialed anew so a forward follows
+	// whatever the dial function currently points to (as after
+	// a reconnect)
+	first, second := echo(t, `1:`), echo(t, `2:`)
+	var current atomic.Value
+	current.Store(first)
+	f := newTestForward(t, func(net.Conn) (net.Conn, error) {
+		return net.Dial(`tcp`, current.Load().(string))
+	})
+	c1, got := roundtrip(t, f.Addr().String(), `a`)
+	defer c1.Close()
+	if got != `1:a` {
+		t.Fatalf(`got %q`, got)
+	}
+	current.Store(second)
+	c2, got := roundtrip(t, f.Addr().String(), `b`)
+	defer c2.Close()
+	if got != `2:b` {
+		t.Fatalf(`got %q`, got)
+	}
+
+	// connections already established stay where they are
+	c1.Write([]byte(`c`))
+	buf := make([]byte, 8)
+	n, _ := c1.Read(buf)
+	if string(buf[:n]) != `1:c` {
+		t.Fatalf(`got %q`, buf[:n])
+	}
+}
+
+func TestForward_Close(t *testing.T) {
+	backend := echo(t, ``)
+	f := newTestForward(t, func(net.Conn) (net.Conn, error) {
+		return net.Dial(`tcp`, backend)
+	})
+	conn, _ := roundtrip(t, f.Addr().String(), `x`)
+	defer conn.Close()
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-f.Done():
+	default:
+		t.Fatal(`Done not closed`)
+	}
+	if f.Err() != nil {
+		t.Fatal(f.Err())
+	}
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf(`expected active connection closed, got %v`, err)
+	}
+	if s := f.Stats(); s.Active != 0 {
+		t.Fatalf(`%+v`, s)
+	}
+	if _, err := net.Dial(`tcp`, f.Addr().String()); err == nil {
+		t.Fatal(`still listening`)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(`second Close:`, err)
+	}
+}
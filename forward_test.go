@@ -0,0 +1,20 @@
+package ssh_test
+
+import (
+	"fmt"
+
+	"github.com/rwxrob/ssh"
+)
+
+func ExampleClient_ForwardLocal() {
+
+	client := new(ssh.Client)
+
+	// udp is not supported on the remote side
+	_, err := client.ForwardLocal(`tcp`, `localhost:0`, `udp`, `db:5432`)
+	fmt.Println(err)
+
+	// Output:
+	// unsupported remote network: udp
+
+}
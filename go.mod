@@ -2,9 +2,10 @@ module github.com/rwxrob/ssh
 
 go 1.21
 
-require golang.org/x/crypto v0.11.0
-
 require (
-	golang.org/x/sys v0.10.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
+	golang.org/x/crypto v0.11.0
+	golang.org/x/sys v0.10.0
+	golang.org/x/term v0.10.0
 )
+
+require gopkg.in/yaml.v3 v3.0.1 // indirect
@@ -0,0 +1,170 @@
+package ssh
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DefaultHealthInterval is the time between health checks of each
+// client when the HealthCheck has no Interval.
+var DefaultHealthInterval = 30 * time.Second
+
+// DefaultHealthTimeout is how long a health check may run when the
+// HealthCheck has no Timeout.
+var DefaultHealthTimeout = 10 * time.Second
+
+// ---------------------------- HealthCheck ---------------------------
+
+// HealthCheck is the health check run on every client of a Controller
+// (see [Controller.StartHealthChecks]). A client passes the check when
+// the command exits with ExitCode and its standard output matches the
+// Output regular expression (if any). A client failing it is unhealthy
+// and no longer selected until it passes again. A HealthCheck may be
+// safely marshaled/unmarshaled to/from JSON/YAML.
+type HealthCheck struct {
+
+	// Command is the command line run for the check. If unset (and there
+	// is no Script) true is run, which checks that a session can be
+	// started and a command run.
+	Command string
+
+	// Script is run instead of Command (if set), see [Script].
+	Script *Script
+
+	// ExitCode is the expected exit status of the command.
+	ExitCode int
+
+	// Output is a regular expression (see [regexp.Compile]) that
+	// standard output must match (optional).
+	Output string
+
+	// Interval is the time between checks of each client. If unset
+	// DefaultHealthInterval is used.
+	Interval time.Duration
+
+	// Timeout is how long the check may run before it fails. If unset
+	// DefaultHealthTimeout is used.
+	Timeout time.Duration
+}
+
+// run runs the health check on client and returns the reason it failed
+// (if it did).
+func (h *HealthCheck) run(client *Client, output *regexp.Regexp) error {
+	cmd, stdin := h.Command, []byte(nil)
+	switch {
+	case h.Script != nil:
+		cmd, stdin = h.Script.command(client.Dialect), []byte(h.Script.Body)
+	case len(strings.TrimSpace(cmd)) == 0:
+		cmd = `true`
+	}
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = DefaultHealthTimeout
+	}
+	r, err := client.Exec(cmd, stdin, WithTimeout(timeout))
+	if connectionError(err) {
+		client.disconnected()
+	}
+	return expect(r, h.ExitCode, output)
+}
+
+// Healthy returns false if the last health check of the client failed
+// (see [Controller.StartHealthChecks]) and true if it passed or the
+// client has never been checked.
+func (c *Client) Healthy() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.healtherr == nil
+}
+
+// HealthError returns the reason the last health check of the client
+// failed or nil if it passed or has never been run.
+func (c *Client) HealthError() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.healtherr
+}
+
+// LastCheck returns when the last health check of the client completed
+// (zero if never).
+func (c *Client) LastCheck() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.checked
+}
+
+// setHealth sets the result of a health check and returns true if the
+// client changed from healthy to unhealthy or back.
+func (c *Client) setHealth(err error) (changed bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	changed = (c.healtherr == nil) != (err == nil)
+	c.healtherr = err
+	c.checked = time.Now()
+	return
+}
+
+// StartHealthChecks runs the Health check (or the default check if
+// unset) on every client in the Clients list right away and then every
+// interval in a separate goroutine for each, connecting any not yet
+// connected (and reconnecting any whose connection is lost) as it goes.
+// Clients failing the check are not selected (see
+// [Controller.Selector]) until they pass again. Any health checks
+// already running are stopped first. Returns an error without starting
+// any checks if the Output of the HealthCheck is not a valid regular
+// expression. Health and Clients must not be changed until the checks
+// are stopped (see [Controller.StopHealthChecks]).
+func (c *Controller) StartHealthChecks() error {
+	h := c.Health
+	if h == nil {
+		h = new(HealthCheck)
+	}
+	var output *regexp.Regexp
+	if len(h.Output) > 0 {
+		var err error
+		if output, err = regexp.Compile(h.Output); err != nil {
+			return err
+		}
+	}
+	interval := h.Interval
+	if interval <= 0 {
+		interval = DefaultHealthInterval
+	}
+	c.StopHealthChecks()
+	stop := make(chan struct{})
+	c.hmu.Lock()
+	c.hstop = stop
+	c.hmu.Unlock()
+	for _, client := range c.Clients {
+		c.hwg.Add(1)
+		go func(client *Client) {
+			defer c.hwg.Done()
+			for {
+				err := h.run(client, output)
+				if client.setHealth(err) {
+					c.emit(Event{Kind: EventHealth, Client: client, Err: err})
+				}
+				select {
+				case <-stop:
+					return
+				case <-time.After(interval):
+				}
+			}
+		}(client)
+	}
+	return nil
+}
+
+// StopHealthChecks stops any health checks started with
+// [Controller.StartHealthChecks] and waits for any still running to
+// complete. The clients keep the health status of their last check.
+func (c *Controller) StopHealthChecks() {
+	c.hmu.Lock()
+	if c.hstop != nil {
+		close(c.hstop)
+		c.hstop = nil
+	}
+	c.hmu.Unlock()
+	c.hwg.Wait()
+}
@@ -0,0 +1,150 @@
+package ssh
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// DefaultHedgeWindow is the number of recent run times of successful
+// commands kept by a Controller to decide when to hedge (see
+// [WithHedge]).
+var DefaultHedgeWindow = 100
+
+// DefaultHedgeMinSamples is the number of recent run times a Controller
+// must have before it starts hedging (see [WithHedge]).
+var DefaultHedgeMinSamples = 10
+
+// Canceled is the error of an attempt that was killed because another
+// one completed first (see [WithHedge]).
+type Canceled struct{}
+
+func (Canceled) Error() string { return `command canceled` }
+
+// ------------------------------ Hedging -----------------------------
+
+// WithHedge makes [Controller.RunOnAny] (and ExecOnAny) start a second
+// attempt of the command on another client (chosen by the Selector as
+// usual) if the first has not completed within the given percentile
+// (between 0 and 100, ex: 95) of the recent run times of successful
+// commands run on any client by the Controller. The first attempt to
+// succeed is used and the other is killed (see [Command.Kill]) in the
+// background and recorded as [Canceled] in the Attempts of the Result.
+// If one attempt fails the other is waited for instead. Both attempts
+// count as one toward the MaxAttempts of the RetryPolicy. No hedging
+// is done until the Controller has observed DefaultHedgeMinSamples run
+// times. Only use with commands that are safe to run more than once
+// (such as those that only read). Ignored when running on a specific
+// Client.
+func WithHedge(percentile float64) RunOption {
+	return func(c *runConfig) { c.hedge = percentile }
+}
+
+// observe adds the run time of a successful command to the recent run
+// times of the Controller.
+func (c *Controller) observe(d time.Duration) {
+	c.lmu.Lock()
+	defer c.lmu.Unlock()
+	if len(c.recent) < DefaultHedgeWindow {
+		c.recent = append(c.recent, d)
+		return
+	}
+	c.recent[c.nextrecent%len(c.recent)] = d
+	c.nextrecent++
+}
+
+// hedgeDelay returns the percentile p of the recent run times and false
+// if there are not enough of them yet.
+func (c *Controller) hedgeDelay(p float64) (time.Duration, bool) {
+	c.lmu.Lock()
+	recent := append([]time.Duration(nil), c.recent...)
+	c.lmu.Unlock()
+	if p <= 0 || len(recent) == 0 || len(recent) < DefaultHedgeMinSamples {
+		return 0, false
+	}
+	sort.Slice(recent, func(i, j int) bool { return recent[i] < recent[j] })
+	i := int(math.Ceil(p/100*float64(len(recent)))) - 1
+	switch {
+	case i < 0:
+		i = 0
+	case i >= len(recent):
+		i = len(recent) - 1
+	}
+	return recent[i], true
+}
+
+// attempt runs the command on client (hedging it on another client not
+// yet tried if enabled, see [WithHedge]) and returns the Result to use
+// along with every attempt made.
+func (c *Controller) attempt(client *Client, tried map[*Client]bool, cfg *runConfig, cmd string, stdin []byte, opts []RunOption) (*Result, []Attempt) {
+	opts = c.options(opts)
+	start := time.Now()
+	x, err := client.Start(cmd, stdin, opts...)
+	if err != nil {
+		r := notStarted(client, err)
+		return r, []Attempt{c.completed(r, start)}
+	}
+	delay, hedge := c.hedgeDelay(cfg.hedge)
+	if !hedge {
+		r := x.Result()
+		return r, []Attempt{c.completed(r, start)}
+	}
+	select {
+	case <-x.Done():
+		r := x.Result()
+		return r, []Attempt{c.completed(r, start)}
+	case <-time.After(delay):
+	}
+	other := c.selectClient(tried, cfg)
+	if other == nil {
+		r := x.Result()
+		return r, []Attempt{c.completed(r, start)}
+	}
+	tried[other] = true
+	starts := map[*Client]time.Time{client: start, other: time.Now()}
+	done := make(chan *Result, 2)
+	p := newPending()
+	go p.watch(client, x, done)
+	go p.run(other, cmd, stdin, opts, done)
+	r := <-done
+	attempts := []Attempt{c.completed(r, starts[r.Client])}
+	if r.Err != nil {
+		r = <-done
+		return r, append(attempts, c.completed(r, starts[r.Client]))
+	}
+	loser := other
+	if r.Client == other {
+		loser = client
+	}
+	p.decide([]*Client{loser}, nil)
+	canceled := Attempt{loser, starts[loser], time.Since(starts[loser]), Canceled{}}
+	return r, append(attempts, canceled)
+}
+
+// completed settles (see [Controller.settle]) a completed attempt
+// started at start and returns it as an Attempt.
+func (c *Controller) completed(r *Result, start time.Time) Attempt {
+	a := Attempt{r.Client, start, time.Since(start), r.Err}
+	c.settle(r)
+	if r.Err == nil {
+		c.observe(a.Duration)
+	}
+	return a
+}
+
+// settle records the outcome of a completed command for the circuit
+// breaker of its client and marks the client disconnected (see
+// [Client.disconnected]) if it lost its connection. A command that was
+// never started for any reason other than its connection (such as an
+// invalid option) says nothing about the client and only gives up any
+// trial reserved.
+func (c *Controller) settle(r *Result) {
+	if r.unstarted && !connectionError(r.Err) {
+		r.Client.release()
+	} else {
+		c.record(r.Client, r.Err)
+	}
+	if connectionError(r.Err) {
+		r.Client.disconnected()
+	}
+}
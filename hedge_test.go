@@ -0,0 +1,65 @@
+package ssh
+
+import (
+	"testing"
+	"time"
+)
+
+func TestController_hedgeDelay(t *testing.T) {
+	defer func(n int) { DefaultHedgeMinSamples = n }(DefaultHedgeMinSamples)
+	DefaultHedgeMinSamples = 10
+
+	// 10ms to 1ms (unsorted on purpose)
+	var recent []time.Duration
+	for i := 10; i > 0; i-- {
+		recent = append(recent, time.Duration(i)*time.Millisecond)
+	}
+	tests := []struct {
+		name   string
+		recent []time.Duration
+		p      float64
+		want   time.Duration
+		ok     bool
+	}{
+		{`median`, recent, 50, 5 * time.Millisecond, true},
+		{`p90`, recent, 90, 9 * time.Millisecond, true},
+		{`p91 rounds up`, recent, 91, 10 * time.Millisecond, true},
+		{`p95`, recent, 95, 10 * time.Millisecond, true},
+		{`p100`, recent, 100, 10 * time.Millisecond, true},
+		{`over 100`, recent, 150, 10 * time.Millisecond, true},
+		{`lowest`, recent, 1, time.Millisecond, true},
+		{`p10`, recent, 10, time.Millisecond, true},
+		{`p11 rounds up`, recent, 11, 2 * time.Millisecond, true},
+		{`zero`, recent, 0, 0, false},
+		{`negative`, recent, -5, 0, false},
+		{`too few`, recent[:9], 50, 0, false},
+		{`none`, nil, 50, 0, false},
+	}
+	for _, tt := range tests {
+		c := &Controller{recent: tt.recent}
+		got, ok := c.hedgeDelay(tt.p)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf(`%v: got %v %v want %v %v`, tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestController_observe(t *testing.T) {
+	defer func(n int) { DefaultHedgeWindow = n }(DefaultHedgeWindow)
+	DefaultHedgeWindow = 3
+	c := new(Controller)
+	for i := 1; i <= 5; i++ {
+		c.observe(time.Duration(i))
+	}
+
+	// the oldest run times are replaced once the window is full
+	want := []time.Duration{4, 5, 3}
+	if len(c.recent) != len(want) {
+		t.Fatal(c.recent)
+	}
+	for i := range want {
+		if c.recent[i] != want[i] {
+			t.Fatalf(`got %v want %v`, c.recent, want)
+		}
+	}
+}
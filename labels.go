@@ -0,0 +1,186 @@
+package ssh
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ---------------------------- LabelQuery ----------------------------
+
+// LabelQuery matches clients by their Labels (see [Client.Labels])
+// using the same syntax as Kubernetes label selectors: a comma
+// separated list of requirements that must all be met.
+//
+//	role=web             label equals value (also role==web)
+//	region!=eu           label missing or not equal to value
+//	env in (prod,stage)  label equals one of the values
+//	env notin (dev)      label missing or not one of the values
+//	canary               label exists (any value)
+//	!canary              label does not exist
+//
+// An empty LabelQuery matches every client. A LabelQuery may be safely
+// marshaled/unmarshaled to/from JSON/YAML as its string form.
+type LabelQuery struct {
+	text string
+	reqs []labelReq
+}
+
+type labelReq struct {
+	key    string
+	op     string // = != in notin exists !exists
+	values []string
+}
+
+var (
+	labelKey   = `[A-Za-z0-9]([-A-Za-z0-9_./]*[A-Za-z0-9])?`
+	labelValue = regexp.MustCompile(`^([A-Za-z0-9]([-A-Za-z0-9_.]*[A-Za-z0-9])?)?$`)
+	labelExist = regexp.MustCompile(`^(!?)\s*(` + labelKey + `)$`)
+	labelEqual = regexp.MustCompile(`^(` + labelKey + `)\s*(==|=|!=)\s*(.*)$`)
+	labelSet   = regexp.MustCompile(`^(` + labelKey + `)\s+(in|notin)\s*\((.*)\)$`)
+)
+
+// ParseLabelQuery parses the text of a LabelQuery (see [LabelQuery])
+// and returns an error if the syntax is invalid.
+func ParseLabelQuery(text string) (LabelQuery, error) {
+	q := LabelQuery{text: strings.TrimSpace(text)}
+	for _, part := range splitLabelQuery(q.text) {
+		part = strings.TrimSpace(part)
+		var r labelReq
+		if m := labelSet.FindStringSubmatch(part); m != nil {
+			r = labelReq{key: m[1], op: m[3]}
+			for _, v := range strings.Split(m[4], `,`) {
+				r.values = append(r.values, strings.TrimSpace(v))
+			}
+		} else if m := labelEqual.FindStringSubmatch(part); m != nil {
+			r = labelReq{key: m[1], op: m[3], values: []string{strings.TrimSpace(m[4])}}
+			if r.op == `==` {
+				r.op = `=`
+			}
+		} else if m := labelExist.FindStringSubmatch(part); m != nil {
+			r = labelReq{key: m[2], op: m[1] + `exists`}
+		} else {
+			return LabelQuery{}, fmt.Errorf(`invalid label requirement: %q`, part)
+		}
+		for _, v := range r.values {
+			if !labelValue.MatchString(v) {
+				return LabelQuery{}, fmt.Errorf(`invalid label value: %q`, v)
+			}
+		}
+		q.reqs = append(q.reqs, r)
+	}
+	return q, nil
+}
+
+// MustParseLabelQuery is the same as [ParseLabelQuery] but panics if
+// the syntax is invalid, which is convenient for literal queries.
+func MustParseLabelQuery(text string) LabelQuery {
+	q, err := ParseLabelQuery(text)
+	if err != nil {
+		panic(err)
+	}
+	return q
+}
+
+// splitLabelQuery splits text on the commas not inside parentheses.
+func splitLabelQuery(text string) []string {
+	if len(text) == 0 {
+		return nil
+	}
+	var parts []string
+	var depth, start int
+	for i, r := range text {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, text[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, text[start:])
+}
+
+// String returns the text the LabelQuery was parsed from.
+func (q LabelQuery) String() string { return q.text }
+
+// MarshalText implements [encoding.TextMarshaler].
+func (q LabelQuery) MarshalText() ([]byte, error) { return []byte(q.text), nil }
+
+// UnmarshalText implements [encoding.TextUnmarshaler].
+func (q *LabelQuery) UnmarshalText(text []byte) error {
+	parsed, err := ParseLabelQuery(string(text))
+	if err != nil {
+		return err
+	}
+	*q = parsed
+	return nil
+}
+
+// Matches returns true if labels meet every requirement of the query.
+func (q LabelQuery) Matches(labels map[string]string) bool {
+	for _, r := range q.reqs {
+		v, has := labels[r.key]
+		var ok bool
+		switch r.op {
+		case `exists`:
+			ok = has
+		case `!exists`:
+			ok = !has
+		case `=`:
+			ok = has && v == r.values[0]
+		case `!=`:
+			ok = !has || v != r.values[0]
+		case `in`:
+			ok = has && contains(r.values, v)
+		case `notin`:
+			ok = !has || !contains(r.values, v)
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(list []string, s string) bool {
+	for _, it := range list {
+		if it == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Where returns a new Controller with only the Clients whose Labels
+// match the query (in order) and every other setting (Selector,
+// RetryPolicy, Breaker, etc.) and the function set with OnEvent shared
+// with the original so that any Controller operation can target
+// a subset of the fleet. The clients themselves are shared (not
+// copied) so their connections, circuit breakers, and health are the
+// same in both. Health checks and recent run times used for hedging
+// are not shared.
+//
+//	web := ctl.Where(ssh.MustParseLabelQuery(`role=web,region!=eu`))
+//	web.RunOnAll(`systemctl reload nginx`, nil)
+func (c *Controller) Where(q LabelQuery) *Controller {
+	sub := &Controller{
+		Clients:        make([]*Client, 0, len(c.Clients)),
+		CommandTimeout: c.CommandTimeout,
+		Selector:       c.Selector,
+		Retry:          c.Retry,
+		Breaker:        c.Breaker,
+		Health:         c.Health,
+		onevent:        c.onevent,
+	}
+	for _, client := range c.Clients {
+		if q.Matches(client.Labels) {
+			sub.Clients = append(sub.Clients, client)
+		}
+	}
+	return sub
+}
@@ -0,0 +1,63 @@
+package ssh_test
+
+import (
+	"fmt"
+
+	"github.com/rwxrob/ssh"
+	"gopkg.in/yaml.v3"
+)
+
+func ExampleLabelQuery_Matches() {
+	labels := map[string]string{`role`: `web`, `region`: `us`, `env`: `prod`}
+	for _, text := range []string{
+		`role=web,region!=eu`,
+		`env in (prod, stage),!canary`,
+		`env notin (prod)`,
+		`canary`,
+		``,
+	} {
+		fmt.Println(ssh.MustParseLabelQuery(text).Matches(labels))
+	}
+	_, err := ssh.ParseLabelQuery(`role=`)
+	fmt.Println(err)
+	_, err = ssh.ParseLabelQuery(`role=web app`)
+	fmt.Println(err)
+	// Output:
+	// true
+	// true
+	// false
+	// false
+	// true
+	// <nil>
+	// invalid label value: "web app"
+}
+
+func ExampleController_Where() {
+	yml := []byte(`
+clients:
+  - host: {addr: web1}
+    labels: {role: web, region: us}
+  - host: {addr: web2}
+    labels: {role: web, region: eu}
+  - host: {addr: db1}
+    labels: {role: db, region: us}
+`)
+	ctl := new(ssh.Controller)
+	if err := yaml.Unmarshal(yml, ctl); err != nil {
+		fmt.Println(err)
+	}
+	for _, c := range ctl.Where(ssh.MustParseLabelQuery(`role=web,region!=eu`)).Clients {
+		fmt.Println(c.Host.Addr)
+	}
+	for _, c := range ctl.Where(ssh.MustParseLabelQuery(`region in (us)`)).Clients {
+		fmt.Println(c.Host.Addr)
+	}
+	out, _ := yaml.Marshal(ctl.Clients[2].Labels)
+	fmt.Print(string(out))
+	// Output:
+	// web1
+	// web1
+	// db1
+	// region: us
+	// role: db
+}
@@ -0,0 +1,145 @@
+package ssh
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// --------------------------- Run Options ----------------------------
+
+// RunOption changes how a single command is run by [Client.Run] and
+// every Controller method that runs commands through it (such as
+// [Controller.RunOnAny]). Options are applied in order so later ones
+// win when they conflict.
+type RunOption func(*runConfig)
+
+type runConfig struct {
+	env []envVar
+	dir string
+	pid bool
+
+	timeout         time.Duration
+	timeoutSet      bool
+	fallbackTimeout time.Duration
+
+	maxOutput    int
+	maxOutputSet bool
+	truncation   Truncation
+	combined     bool
+
+	template bool
+	vars     map[string]any
+	script   *Script
+
+	become   *Become
+	key      string
+	affinity bool
+	hedge    float64
+}
+
+type envVar struct{ name, value string }
+
+func newRunConfig(opts []RunOption) *runConfig {
+	cfg := new(runConfig)
+	for _, opt := range opts {
+		if opt != nil {
+			opt(cfg)
+		}
+	}
+	return cfg
+}
+
+// WithEnv sets the environment variable name to value for the remote
+// command. It is first sent with an SSH env request (see
+// [ssh.Session.Setenv]) and, only if the server refuses it (as OpenSSH
+// does for any name not listed in its AcceptEnv setting), exported with
+// safely quoted shell syntax prepended to the command line instead.
+// Names must be valid POSIX shell variable names or running the
+// command returns an error. May be used more than once. The shell
+// syntax used follows the [Client.Dialect] of the target host (and
+// values with line breaks cannot be set with the syntax of Cmd).
+func WithEnv(name, value string) RunOption {
+	return func(c *runConfig) {
+		for i, v := range c.env {
+			if v.name == name {
+				c.env[i].value = value
+				return
+			}
+		}
+		c.env = append(c.env, envVar{name, value})
+	}
+}
+
+// WithDir changes to the dir directory on the target host before
+// running the command and fails without running it if the directory
+// cannot be changed into. Relative paths are relative to the initial
+// directory of the user (usually home) and dir is quoted so no tilde
+// or other shell expansion takes place.
+func WithDir(dir string) RunOption {
+	return func(c *runConfig) { c.dir = dir }
+}
+
+// WithTimeout kills the command and returns CommandTimedOut if it runs
+// longer than timeout, overriding the CommandTimeout of the Client and
+// Controller. A timeout of zero disables any command timeout.
+func WithTimeout(timeout time.Duration) RunOption {
+	return func(c *runConfig) {
+		c.timeout = timeout
+		c.timeoutSet = true
+	}
+}
+
+// commandTimeout returns the command timeout for a command run on
+// client.
+func (c *runConfig) commandTimeout(client *Client) time.Duration {
+	switch {
+	case c.timeoutSet:
+		return c.timeout
+	case client.CommandTimeout > 0:
+		return client.CommandTimeout
+	}
+	return c.fallbackTimeout
+}
+
+// outputLimit returns the maximum size and truncation policy for
+// captured output.
+func (c *runConfig) outputLimit() (int, Truncation) {
+	if c.maxOutputSet {
+		return c.maxOutput, c.truncation
+	}
+	return DefaultMaxOutput, KeepHead
+}
+
+var envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// prepare sends the environment requests with setenv (the Setenv of
+// the session) and returns the final command line with any
+// environment variables refused by the server and working directory
+// prepended in the shell syntax of dialect. Environment variables are
+// never sent as requests when becoming another user (see
+// [WithBecome]). Since cmd.exe ends the command line at the first line
+// break, values and directories containing one are refused for Cmd.
+func (c *runConfig) prepare(setenv func(name, value string) error, cmd string, dialect Dialect) (string, error) {
+	var prefix string
+	for _, v := range c.env {
+		if !envName.MatchString(v.name) {
+			return ``, fmt.Errorf(`invalid environment variable name: %q`, v.name)
+		}
+		if c.become == nil && setenv(v.name, v.value) == nil {
+			continue
+		}
+		if dialect == Cmd && strings.ContainsAny(v.value, "\r\n") {
+			return ``, fmt.Errorf(`line break in environment variable not supported by cmd: %q`, v.name)
+		}
+		prefix += dialect.setenv(v.name, v.value)
+	}
+	if len(c.dir) > 0 {
+		if dialect == Cmd && strings.ContainsAny(c.dir, "\r\n") {
+			return ``, fmt.Errorf(`line break in directory not supported by cmd: %q`, c.dir)
+		}
+		prefix += dialect.chdir(c.dir)
+	}
+	return prefix + cmd, nil
+}
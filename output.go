@@ -0,0 +1,237 @@
+package ssh
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"time"
+)
+
+// DefaultMaxOutput is the maximum number of bytes of standard output
+// and of standard error (each) captured for a command when not set
+// with WithMaxOutput. Zero means no limit.
+var DefaultMaxOutput = 0
+
+// ----------------------------- Truncation ----------------------------
+
+// Truncation is the policy deciding which part of captured output is
+// kept once it exceeds the maximum size (see [WithMaxOutput]).
+type Truncation int
+
+const (
+	KeepHead     Truncation = iota // keep the first bytes
+	KeepTail                       // keep the last bytes
+	KeepHeadTail                   // keep the first and last half
+)
+
+// WithMaxOutput limits the captured standard output and standard error
+// of the command to max bytes each, keeping the part chosen by keep
+// and discarding the rest as it arrives so that memory use stays
+// bounded no matter how much the command writes. Output that exceeds
+// the limit is flagged as truncated in the [Result]. A max of zero
+// disables the limit (overriding DefaultMaxOutput).
+func WithMaxOutput(max int, keep Truncation) RunOption {
+	return func(c *runConfig) {
+		c.maxOutput = max
+		c.maxOutputSet = true
+		c.truncation = keep
+	}
+}
+
+// buffer is a bytes.Buffer safe for concurrent writing and reading
+// that keeps at most max bytes (if max is greater than zero) according
+// to its keep policy.
+type buffer struct {
+	mu        sync.Mutex
+	max       int
+	keep      Truncation
+	head      bytes.Buffer
+	tail      bytes.Buffer
+	truncated bool
+}
+
+func newBuffer(max int, keep Truncation) *buffer {
+	return &buffer{max: max, keep: keep}
+}
+
+func (b *buffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := len(p)
+	if b.max <= 0 {
+		return b.head.Write(p)
+	}
+	headmax := b.max
+	switch b.keep {
+	case KeepTail:
+		headmax = 0
+	case KeepHeadTail:
+		headmax = b.max / 2
+	}
+	if room := headmax - b.head.Len(); room > 0 {
+		if room > len(p) {
+			room = len(p)
+		}
+		b.head.Write(p[:room])
+		p = p[room:]
+	}
+	if len(p) == 0 {
+		return n, nil
+	}
+	tailmax := b.max - headmax
+	if tailmax == 0 {
+		b.truncated = true
+		return n, nil
+	}
+	if len(p) > tailmax {
+		p = p[len(p)-tailmax:]
+		b.truncated = true
+	}
+	if over := b.tail.Len() + len(p) - tailmax; over > 0 {
+		b.tail.Next(over)
+		b.truncated = true
+	}
+	b.tail.Write(p)
+	return n, nil
+}
+
+// String returns the kept output.
+func (b *buffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.head.String() + b.tail.String()
+}
+
+// Truncated returns true if any output has been discarded.
+func (b *buffer) Truncated() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.truncated
+}
+
+// ------------------------------ Chunks ------------------------------
+
+// Stream identifies the output stream a Chunk was written to.
+type Stream int
+
+const (
+	StreamStdout Stream = 1
+	StreamStderr Stream = 2
+)
+
+// String returns stdout or stderr.
+func (s Stream) String() string {
+	if s == StreamStderr {
+		return `stderr`
+	}
+	return `stdout`
+}
+
+// Chunk is a single write of output by the remote command as received
+// by the client.
+type Chunk struct {
+	Stream Stream
+	Time   time.Time
+	Data   string
+}
+
+// WithCombined records every chunk of standard output and standard
+// error in the order received along with the stream it was written to
+// and when it arrived (see [Result.Chunks]) so that both the combined
+// view (see [Result.Combined]) and the separate views (Stdout and
+// Stderr) are available from the same run. Note that the SSH protocol
+// sends both streams independently so the order of writes to different
+// streams that happen very close together on the target host is not
+// guaranteed. The total size of all chunks is limited the same way as
+// each stream (see [WithMaxOutput]).
+func WithCombined() RunOption {
+	return func(c *runConfig) { c.combined = true }
+}
+
+// recorder keeps the chunks written to any of its stream writers
+// limited to max total bytes (if greater than zero) according to its
+// keep policy.
+type recorder struct {
+	mu        sync.Mutex
+	max       int
+	keep      Truncation
+	head      []Chunk
+	headsize  int
+	tail      []Chunk
+	tailsize  int
+	truncated bool
+}
+
+func newRecorder(max int, keep Truncation) *recorder {
+	return &recorder{max: max, keep: keep}
+}
+
+// writer returns an io.Writer recording every write as a Chunk of
+// stream.
+func (r *recorder) writer(stream Stream) io.Writer {
+	return writerFunc(func(p []byte) (int, error) {
+		r.add(Chunk{stream, time.Now(), string(p)})
+		return len(p), nil
+	})
+}
+
+func (r *recorder) add(c Chunk) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.max <= 0 {
+		r.head = append(r.head, c)
+		return
+	}
+	headmax := r.max
+	switch r.keep {
+	case KeepTail:
+		headmax = 0
+	case KeepHeadTail:
+		headmax = r.max / 2
+	}
+	if room := headmax - r.headsize; room > 0 {
+		part := c
+		if len(part.Data) > room {
+			part.Data = part.Data[:room]
+		}
+		r.head = append(r.head, part)
+		r.headsize += len(part.Data)
+		c.Data = c.Data[len(part.Data):]
+	}
+	if len(c.Data) == 0 {
+		return
+	}
+	tailmax := r.max - headmax
+	if tailmax == 0 {
+		r.truncated = true
+		return
+	}
+	r.tail = append(r.tail, c)
+	r.tailsize += len(c.Data)
+	for r.tailsize > tailmax {
+		r.truncated = true
+		over := r.tailsize - tailmax
+		if over >= len(r.tail[0].Data) {
+			r.tailsize -= len(r.tail[0].Data)
+			r.tail = r.tail[1:]
+			continue
+		}
+		r.tail[0].Data = r.tail[0].Data[over:]
+		r.tailsize -= over
+	}
+}
+
+// chunks returns a copy of the recorded chunks and whether any were
+// discarded or shortened.
+func (r *recorder) chunks() ([]Chunk, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	chunks := make([]Chunk, 0, len(r.head)+len(r.tail))
+	chunks = append(chunks, r.head...)
+	chunks = append(chunks, r.tail...)
+	return chunks, r.truncated
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
@@ -0,0 +1,79 @@
+package ssh
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBuffer(t *testing.T) {
+	tests := []struct {
+		name      string
+		max       int
+		keep      Truncation
+		writes    []string
+		want      string
+		truncated bool
+	}{
+		{`unlimited`, 0, KeepHead, []string{`abc`, `def`}, `abcdef`, false},
+		{`head`, 5, KeepHead, []string{`abc`, `defg`, `hij`}, `abcde`, true},
+		{`tail`, 5, KeepTail, []string{`abc`, `defg`, `hi`}, `efghi`, true},
+		{`tail one big write`, 3, KeepTail, []string{`abcdefgh`}, `fgh`, true},
+		{`head+tail`, 6, KeepHeadTail, []string{`ab`, `cdef`, `ghij`, `k`}, `abcijk`, true},
+		{`head+tail odd max`, 5, KeepHeadTail, []string{`abcdefghij`}, `abhij`, true},
+		{`head exact`, 4, KeepHead, []string{`ab`, `cd`}, `abcd`, false},
+		{`tail exact`, 4, KeepTail, []string{`ab`, `cd`}, `abcd`, false},
+		{`head+tail exact`, 4, KeepHeadTail, []string{`ab`, `cd`}, `abcd`, false},
+		{`head one over`, 4, KeepHead, []string{`abcd`, `e`}, `abcd`, true},
+		{`tail one over`, 4, KeepTail, []string{`abcd`, `e`}, `bcde`, true},
+		{`head+tail one over`, 4, KeepHeadTail, []string{`abcd`, `e`}, `abde`, true},
+		{`empty writes`, 2, KeepTail, []string{``, `a`, ``}, `a`, false},
+	}
+	for _, tt := range tests {
+		b := newBuffer(tt.max, tt.keep)
+		for _, w := range tt.writes {
+			if n, err := b.Write([]byte(w)); n != len(w) || err != nil {
+				t.Fatalf(`%v: Write returned %v, %v`, tt.name, n, err)
+			}
+		}
+		if got := b.String(); got != tt.want || b.Truncated() != tt.truncated {
+			t.Errorf(`%v: got %q %v want %q %v`, tt.name, got, b.Truncated(), tt.want, tt.truncated)
+		}
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	chunks := []Chunk{
+		{Stream: StreamStdout, Data: `abc`},
+		{Stream: StreamStderr, Data: `def`},
+		{Stream: StreamStdout, Data: `g`},
+	}
+	tests := []struct {
+		name      string
+		max       int
+		keep      Truncation
+		n         int // number of chunks added
+		want      string
+		truncated bool
+	}{
+		{`unlimited`, 0, KeepHead, 3, `stdout:abc stderr:def stdout:g`, false},
+		{`head`, 5, KeepHead, 3, `stdout:abc stderr:de`, true},
+		{`tail`, 5, KeepTail, 3, `stdout:c stderr:def stdout:g`, true},
+		{`head+tail`, 4, KeepHeadTail, 3, `stdout:ab stderr:f stdout:g`, true},
+		{`exact`, 6, KeepHeadTail, 2, `stdout:abc stderr:def`, false},
+		{`tail drops whole chunks`, 1, KeepTail, 3, `stdout:g`, true},
+	}
+	for _, tt := range tests {
+		r := newRecorder(tt.max, tt.keep)
+		for _, c := range chunks[:tt.n] {
+			r.add(c)
+		}
+		got, truncated := r.chunks()
+		var parts []string
+		for _, c := range got {
+			parts = append(parts, c.Stream.String()+`:`+c.Data)
+		}
+		if s := strings.Join(parts, ` `); s != tt.want || truncated != tt.truncated {
+			t.Errorf(`%v: got %q %v want %q %v`, tt.name, s, truncated, tt.want, tt.truncated)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package ssh
+
+import (
+	"io"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// DefaultTerm is the terminal type requested for interactive sessions
+// when neither [Terminal.Term] nor the TERM environment variable is
+// set.
+var DefaultTerm = `xterm-256color`
+
+// DefaultTerminalModes are requested for interactive sessions when
+// [Terminal.Modes] is nil.
+var DefaultTerminalModes = ssh.TerminalModes{
+	ssh.ECHO:          1,
+	ssh.TTY_OP_ISPEED: 14400,
+	ssh.TTY_OP_OSPEED: 14400,
+}
+
+// ----------------------------- Terminal -----------------------------
+
+// Terminal contains the settings for an interactive session with
+// a pseudo-terminal (see [Client.Interact]). The zero value uses the
+// standard input, output, and error of the current process and is what
+// is used when a nil Terminal is passed.
+type Terminal struct {
+
+	// Term is the terminal type (TERM) requested for the remote
+	// pseudo-terminal. If unset the local TERM environment variable is
+	// used or DefaultTerm if that is also unset.
+	Term string
+
+	// Modes are the terminal modes requested for the remote
+	// pseudo-terminal. If nil DefaultTerminalModes is used.
+	Modes ssh.TerminalModes
+
+	// Width and Height of the remote pseudo-terminal when In is not
+	// a terminal. Default to 80 by 24.
+	Width  int
+	Height int
+
+	// In, Out, and Err default to os.Stdin, os.Stdout, and os.Stderr.
+	// When In is an *os.File connected to a terminal it is put into raw
+	// mode for the duration of the session and its size propagated to
+	// the remote pseudo-terminal whenever it changes. Nothing more is
+	// read from In once Interact returns.
+	In  io.Reader
+	Out io.Writer
+	Err io.Writer
+}
+
+// fd returns the file descriptor of In if it is a terminal.
+func (t *Terminal) fd() (int, bool) {
+	f, is := t.In.(*os.File)
+	if !is {
+		return 0, false
+	}
+	fd := int(f.Fd())
+	return fd, term.IsTerminal(fd)
+}
+
+// size returns the current width and height of the local terminal (or
+// the fixed Width and Height) to request for the remote one.
+func (t *Terminal) size() (w, h int) {
+	w, h = t.Width, t.Height
+	if fd, is := t.fd(); is {
+		if tw, th, err := term.GetSize(fd); err == nil {
+			w, h = tw, th
+		}
+	}
+	if w <= 0 {
+		w = 80
+	}
+	if h <= 0 {
+		h = 24
+	}
+	return
+}
+
+// Interact runs cmd (or the login shell of the user if cmd is empty) on
+// the target host attached to a pseudo-terminal so that full-screen and
+// tty-dependent programs (top, vi, sudo with requiretty) work as they
+// would from the ssh command. The settings may be nil to use the
+// standard input, output, and error of the current process (see
+// [Terminal]). The local terminal (if any) is put into raw mode and
+// always restored before returning, and window size changes are sent
+// to the remote pseudo-terminal as they happen. If the Client has not
+// yet connected [Client.Connect] is called first. Returns an
+// [ssh.ExitError] if the remote command exits with a non-zero status.
+func (c *Client) Interact(cmd string, settings *Terminal) error {
+	t := Terminal{}
+	if settings != nil {
+		t = *settings
+	}
+	if t.In == nil {
+		t.In = os.Stdin
+	}
+	if t.Out == nil {
+		t.Out = os.Stdout
+	}
+	if t.Err == nil {
+		t.Err = os.Stderr
+	}
+	if len(t.Term) == 0 {
+		t.Term = os.Getenv(`TERM`)
+	}
+	if len(t.Term) == 0 {
+		t.Term = DefaultTerm
+	}
+	if t.Modes == nil {
+		t.Modes = DefaultTerminalModes
+	}
+
+	defer c.acquire()()
+	client, err := c.connection()
+	if err != nil {
+		return err
+	}
+	sess, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+
+	w, h := t.size()
+	if err := sess.RequestPty(t.Term, h, w, t.Modes); err != nil {
+		return err
+	}
+	stdin := &stoppable{r: t.In, stop: make(chan struct{})}
+	defer close(stdin.stop)
+	sess.Stdin = stdin
+	sess.Stdout = t.Out
+	sess.Stderr = t.Err
+
+	if fd, is := t.fd(); is {
+		state, err := term.MakeRaw(fd)
+		if err != nil {
+			return err
+		}
+		defer term.Restore(fd, state)
+		stop := watchSize(func() {
+			nw, nh := t.size()
+			if nw != w || nh != h {
+				w, h = nw, nh
+				sess.WindowChange(h, w)
+			}
+		})
+		defer stop()
+	}
+
+	if len(cmd) == 0 {
+		err = sess.Shell()
+	} else {
+		err = sess.Start(cmd)
+	}
+	if err != nil {
+		return err
+	}
+	return sess.Wait()
+}
+
+// Shell is shorthand for Interact with an empty command and nil
+// settings, which starts the login shell of the user on the target host
+// attached to the terminal of the current process.
+func (c *Client) Shell() error { return c.Interact(``, nil) }
+
+// stoppable reads from r until stop is closed and then returns io.EOF
+// without reading any more so that input (such as the next keystroke
+// on the terminal of the current process) is never consumed once
+// Interact has returned. Reads from an *os.File wait until it is
+// readable first (see readable) so that no read is left blocked.
+type stoppable struct {
+	r    io.Reader
+	stop chan struct{}
+}
+
+func (s *stoppable) Read(p []byte) (int, error) {
+	if f, is := s.r.(*os.File); is {
+		if !readable(f, s.stop) {
+			return 0, io.EOF
+		}
+	} else {
+		select {
+		case <-s.stop:
+			return 0, io.EOF
+		default:
+		}
+	}
+	return s.r.Read(p)
+}
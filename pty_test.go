@@ -0,0 +1,50 @@
+package ssh
+
+import (
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestStoppable(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	s := &stoppable{r: r, stop: make(chan struct{})}
+
+	w.Write([]byte(`a`))
+	buf := make([]byte, 8)
+	if n, err := s.Read(buf); err != nil || string(buf[:n]) != `a` {
+		t.Fatalf(`got %q %v`, buf[:n], err)
+	}
+
+	// a read blocked waiting for input returns once stopped
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.Read(buf)
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(s.stop)
+	select {
+	case err := <-done:
+		if err != io.EOF {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal(`read not stopped`)
+	}
+
+	// and input after that is left for the next reader
+	w.Write([]byte(`b`))
+	if n, err := r.Read(buf); err != nil || string(buf[:n]) != `b` {
+		t.Fatalf(`got %q %v`, buf[:n], err)
+	}
+	if _, err := s.Read(buf); err != io.EOF {
+		t.Fatal(err)
+	}
+}
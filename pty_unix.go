@@ -0,0 +1,59 @@
+//go:build !windows
+
+package ssh
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// watchSize calls changed every time the process receives a SIGWINCH
+// until the returned stop function is called.
+func watchSize(changed func()) (stop func()) {
+	sigs := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(sigs, syscall.SIGWINCH)
+	go func() {
+		for {
+			select {
+			case <-sigs:
+				changed()
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(sigs)
+		close(done)
+	}
+}
+
+// readable waits until f has data to read and returns true or returns
+// false without reading as soon as stop is closed.
+func readable(f *os.File, stop <-chan struct{}) bool {
+	fds := []unix.PollFd{{Fd: int32(f.Fd()), Events: unix.POLLIN}}
+	for {
+		select {
+		case <-stop:
+			return false
+		default:
+		}
+		n, err := unix.Poll(fds, 100)
+		switch {
+		case err == unix.EINTR || n == 0:
+			continue
+		case err != nil:
+			return true // let Read report the error
+		}
+		select {
+		case <-stop:
+			return false
+		default:
+			return true
+		}
+	}
+}
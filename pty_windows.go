@@ -0,0 +1,41 @@
+//go:build windows
+
+package ssh
+
+import (
+	"os"
+	"time"
+)
+
+// watchSize calls changed periodically (since Windows consoles have no
+// SIGWINCH equivalent) until the returned stop function is called.
+func watchSize(changed func()) (stop func()) {
+	tick := time.NewTicker(500 * time.Millisecond)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-tick.C:
+				changed()
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		tick.Stop()
+		close(done)
+	}
+}
+
+// readable always returns true unless stop is already closed since
+// Windows consoles cannot be polled (so one more read may be made after
+// stop is closed).
+func readable(f *os.File, stop <-chan struct{}) bool {
+	select {
+	case <-stop:
+		return false
+	default:
+		return true
+	}
+}
@@ -0,0 +1,205 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// NotEnough is returned when fewer clients than needed succeeded (see
+// [Controller.RunQuorum]) or responded (see [Controller.RunFirst]).
+type NotEnough struct {
+	Need int
+	Got  int
+}
+
+func (e NotEnough) Error() string {
+	return fmt.Sprintf(`needed %v clients but got %v`, e.Need, e.Got)
+}
+
+// ------------------------------ Quorum ------------------------------
+
+// RunQuorum runs cmd with optional standard input concurrently on
+// every available client (see [Controller.RandomClient]) and returns as
+// soon as k of them succeed or so many have failed that k no longer
+// can, killing the commands still running (see [Command.Kill]) in the
+// background either way. The results of the commands that completed
+// are returned in the order they did along with a [NotEnough] error if
+// fewer than k succeeded. Nothing is run if k is more than the number
+// of available clients (which fails right away) or k is 0 or less
+// (which always succeeds).
+func (c *Controller) RunQuorum(k int, cmd string, stdin []byte, opts ...RunOption) ([]*Result, error) {
+	t := &tally{need: k, counts: func(r *Result) bool { return r.Err == nil }}
+	return c.race(cmd, stdin, opts, t)
+}
+
+// RunFirst runs cmd with optional standard input concurrently on every
+// available client (see [Controller.RandomClient]) and returns as soon
+// as n of them respond or so many have not that n no longer can,
+// killing the commands still running (see [Command.Kill]) in the
+// background. A response is any command that ran to completion on the
+// target host no matter its exit status, which excludes those that
+// could not be started, lost their connection, or timed out (see
+// [CommandTimedOut]). All results of the commands that completed are
+// returned in the order they did (including those that are not
+// responses) along with a [NotEnough] error if fewer than n responded.
+// Nothing is run if n is more than the number of available clients or
+// 0 or less (as with [Controller.RunQuorum]). RunFirst with an n of
+// 1 is a hedged run that takes the fastest client.
+func (c *Controller) RunFirst(n int, cmd string, stdin []byte, opts ...RunOption) ([]*Result, error) {
+	t := &tally{need: n, counts: responded}
+	return c.race(cmd, stdin, opts, t)
+}
+
+// responded returns true if the command of r ran to completion on the
+// target host (see [Controller.RunFirst]).
+func responded(r *Result) bool {
+	return !r.unstarted && !connectionError(r.Err) &&
+		!errors.As(r.Err, new(CommandTimedOut))
+}
+
+// tally counts the Results of a race that count toward the number
+// needed and those that do not to decide when the race is over.
+type tally struct {
+	need   int
+	total  int // number of clients started
+	got    int
+	missed int
+	counts func(r *Result) bool
+}
+
+// add counts r and returns true if the race is decided.
+func (t *tally) add(r *Result) bool {
+	if t.counts(r) {
+		t.got++
+	} else {
+		t.missed++
+	}
+	return t.decided()
+}
+
+// decided returns true once enough Results have counted or so many
+// have not that too few remain for enough to.
+func (t *tally) decided() bool {
+	return t.got >= t.need || t.missed > t.total-t.need
+}
+
+// err returns the error for a decided race.
+func (t *tally) err() error {
+	switch {
+	case t.total == 0:
+		return AllUnavailable{}
+	case t.got < t.need:
+		return NotEnough{t.need, t.got}
+	}
+	return nil
+}
+
+// race starts cmd concurrently on every available client and adds each
+// Result to the tally as it completes until it is decided (which may
+// be before starting any) or all have completed, then abandons the
+// commands still running and returns the Results added in order along
+// with the error of the tally.
+func (c *Controller) race(cmd string, stdin []byte, opts []RunOption, t *tally) ([]*Result, error) {
+	opts = c.options(opts)
+	var clients []*Client
+	exclude := map[*Client]bool{}
+	for {
+		client := c.selectClient(exclude, nil)
+		if client == nil {
+			break
+		}
+		exclude[client] = true
+		clients = append(clients, client)
+	}
+	t.total = len(clients)
+	p := newPending()
+	if t.total == 0 || t.decided() {
+		p.decide(clients, nil)
+		return nil, t.err()
+	}
+	done := make(chan *Result, len(clients))
+	for _, client := range clients {
+		go p.run(client, cmd, stdin, opts, done)
+	}
+	var results []*Result
+	finished := map[*Client]bool{}
+	for len(results) < len(clients) {
+		r := <-done
+		c.settle(r)
+		finished[r.Client] = true
+		results = append(results, r)
+		if t.add(r) {
+			break
+		}
+	}
+	p.decide(clients, finished)
+	return results, t.err()
+}
+
+// pending tracks the commands started concurrently (each on its own
+// client) for a single run so that those not needed once it has been
+// decided can be abandoned, including any that have yet to start
+// because they are waiting for a session slot (see
+// [Client.MaxSessions]).
+type pending struct {
+	mu      sync.Mutex
+	decided bool
+	running map[*Client]*Command
+}
+
+func newPending() *pending {
+	return &pending{running: map[*Client]*Command{}}
+}
+
+// run starts the command on client and sends its Result to done once
+// completed unless it is abandoned first.
+func (p *pending) run(client *Client, cmd string, stdin []byte, opts []RunOption, done chan<- *Result) {
+	x, err := client.Start(cmd, stdin, opts...)
+	if err != nil {
+		done <- notStarted(client, err)
+		return
+	}
+	p.watch(client, x, done)
+}
+
+// watch sends the Result of the command already started on client to
+// done once completed unless it is abandoned first.
+func (p *pending) watch(client *Client, x *Command, done chan<- *Result) {
+	p.mu.Lock()
+	if p.decided {
+		p.mu.Unlock()
+		abandon(x)
+		return
+	}
+	p.running[client] = x
+	p.mu.Unlock()
+	done <- x.Result()
+}
+
+// decide abandons the commands of every client not finished in the
+// background (or when they start if they have not yet) and gives up
+// any trial command reserved for it by the circuit breaker (see
+// [Client.release]).
+func (p *pending) decide(clients []*Client, finished map[*Client]bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.decided = true
+	for _, client := range clients {
+		if finished[client] {
+			continue
+		}
+		if x := p.running[client]; x != nil {
+			go abandon(x)
+			continue
+		}
+		client.release()
+	}
+}
+
+// abandon kills the command (see [Command.Kill]) without recording an
+// outcome for its client.
+func abandon(x *Command) {
+	x.Kill()
+	x.client.release()
+}
@@ -0,0 +1,92 @@
+package ssh
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestTally(t *testing.T) {
+	ok := &Result{}
+	bad := &Result{Err: errors.New(`failed`)}
+	tests := []struct {
+		name    string
+		need    int
+		total   int
+		results []*Result // added in order until decided
+		added   int       // number added when decided (0 before any)
+		err     error
+	}{
+		{`quorum`, 2, 3, []*Result{ok, bad, ok}, 3, nil},
+		{`quorum early`, 2, 3, []*Result{ok, ok, bad}, 2, nil},
+		{`impossible`, 2, 3, []*Result{bad, bad, ok}, 2, NotEnough{2, 0}},
+		{`impossible after some`, 3, 4, []*Result{ok, bad, bad, ok}, 3, NotEnough{3, 1}},
+		{`all`, 3, 3, []*Result{ok, ok, ok}, 3, nil},
+		{`all but one failed`, 3, 3, []*Result{ok, bad, ok}, 2, NotEnough{3, 1}},
+		{`more than total`, 4, 3, []*Result{ok, ok, ok}, 0, NotEnough{4, 0}},
+		{`zero`, 0, 3, []*Result{bad}, 0, nil},
+		{`negative`, -1, 3, []*Result{bad}, 0, nil},
+		{`none available`, 1, 0, nil, 0, AllUnavailable{}},
+		{`none available zero`, 0, 0, nil, 0, AllUnavailable{}},
+	}
+	for _, tt := range tests {
+		x := &tally{need: tt.need, total: tt.total, counts: func(r *Result) bool { return r.Err == nil }}
+		added := 0
+		for _, r := range tt.results {
+			if x.decided() {
+				break
+			}
+			added++
+			x.add(r)
+		}
+		if !x.decided() {
+			t.Errorf(`%v: not decided`, tt.name)
+		}
+		if added != tt.added {
+			t.Errorf(`%v: decided after %v results want %v`, tt.name, added, tt.added)
+		}
+		if err := x.err(); err != tt.err {
+			t.Errorf(`%v: got %v want %v`, tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestResponded(t *testing.T) {
+	dial := &net.OpError{Op: `dial`, Net: `tcp`, Err: errors.New(`refused`)}
+	tests := []struct {
+		name string
+		r    *Result
+		want bool
+	}{
+		{`success`, &Result{}, true},
+		{`non-zero exit`, &Result{ExitCode: 1, Err: &ssh.ExitError{}}, true},
+		{`not connected`, notStarted(nil, dial), false},
+		{`not started`, notStarted(nil, errors.New(`invalid environment variable name`)), false},
+		{`lost`, &Result{ExitCode: -1, Err: io.EOF}, false},
+		{`exit missing`, &Result{ExitCode: -1, Err: &ssh.ExitMissingError{}}, false},
+		{`timed out`, &Result{ExitCode: -1, Err: CommandTimedOut{Limit: time.Second}}, false},
+	}
+	for _, tt := range tests {
+		if got := responded(tt.r); got != tt.want {
+			t.Errorf(`%v: got %v want %v`, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestController_RunQuorum_unavailable(t *testing.T) {
+	ctl := new(Controller)
+	for _, k := range []int{-1, 0, 1} {
+		results, err := ctl.RunQuorum(k, `true`, nil)
+		if len(results) != 0 || err != (AllUnavailable{}) {
+			t.Errorf(`k %v: got %v %v`, k, results, err)
+		}
+		results, err = ctl.RunFirst(k, `true`, nil)
+		if len(results) != 0 || err != (AllUnavailable{}) {
+			t.Errorf(`n %v: got %v %v`, k, results, err)
+		}
+	}
+}
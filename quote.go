@@ -0,0 +1,178 @@
+package ssh
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ------------------------------ Dialect -----------------------------
+
+// Dialect is the command line syntax of the shell used by the SSH
+// server on the target host to run commands. The zero value is POSIX.
+// A Dialect marshals to and from its name (posix, cmd, powershell) so
+// it can be set from JSON/YAML.
+type Dialect int
+
+const (
+	POSIX      Dialect = iota // sh, bash, zsh, etc.
+	Cmd                       // Windows OpenSSH default cmd.exe
+	PowerShell                // Windows OpenSSH with DefaultShell powershell
+)
+
+var dialectNames = []string{`posix`, `cmd`, `powershell`}
+
+// String returns the lowercase name of the dialect.
+func (d Dialect) String() string {
+	if d < 0 || int(d) >= len(dialectNames) {
+		return fmt.Sprintf(`Dialect(%d)`, int(d))
+	}
+	return dialectNames[d]
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (d Dialect) MarshalText() ([]byte, error) { return []byte(d.String()), nil }
+
+// UnmarshalText implements encoding.TextUnmarshaler (ignoring case).
+func (d *Dialect) UnmarshalText(text []byte) error {
+	for i, name := range dialectNames {
+		if strings.EqualFold(string(text), name) {
+			*d = Dialect(i)
+			return nil
+		}
+	}
+	return fmt.Errorf(`unknown dialect: %q`, text)
+}
+
+// Quote returns arg quoted (only if needed) so that it is passed as
+// exactly one argument unchanged to the command when part of a command
+// line in the dialect.
+//
+//   - POSIX wraps arg in single quotes (escaping any within it).
+//   - Cmd applies the Microsoft C runtime argument quoting rules and
+//     then escapes every cmd.exe metacharacter with a caret (^).
+//   - PowerShell wraps arg in single quotes (doubling any within it).
+func (d Dialect) Quote(arg string) string {
+	switch d {
+	case Cmd:
+		return caretEscape(crtQuote(arg))
+	case PowerShell:
+		if len(arg) > 0 && !strings.ContainsAny(arg, " \t\r\n'\"‘’‚‛“”„`$@#&|;,(){}<>") {
+			return arg
+		}
+		for _, q := range []string{`'`, `‘`, `’`, `‚`, `‛`} {
+			arg = strings.ReplaceAll(arg, q, q+q)
+		}
+		return `'` + arg + `'`
+	default:
+		if len(arg) > 0 && strings.Trim(arg, posixSafe) == `` {
+			return arg
+		}
+		return `'` + strings.ReplaceAll(arg, `'`, `'\''`) + `'`
+	}
+}
+
+// Join returns a command line in the dialect that runs the command
+// argv[0] with the remaining arguments each quoted with [Dialect.Quote]
+// so that none of them are subject to any shell interpretation.
+// PowerShell command lines are prefixed with the call operator (&) so
+// that a quoted command name is still invoked. Returns an empty string
+// if argv is empty.
+func (d Dialect) Join(argv ...string) string {
+	if len(argv) == 0 {
+		return ``
+	}
+	quoted := make([]string, len(argv))
+	for i, arg := range argv {
+		quoted[i] = d.Quote(arg)
+	}
+	line := strings.Join(quoted, ` `)
+	if d == PowerShell {
+		line = `& ` + line
+	}
+	return line
+}
+
+// setenv returns a command line fragment in the dialect that sets the
+// environment variable for the rest of the command line.
+func (d Dialect) setenv(name, value string) string {
+	switch d {
+	case Cmd:
+		return `set ` + name + `=` + caretEscape(value) + `&`
+	case PowerShell:
+		return `$env:` + name + `=` + PowerShell.Quote(value) + `; `
+	default:
+		return `export ` + name + `=` + POSIX.Quote(value) + `; `
+	}
+}
+
+// chdir returns a command line fragment in the dialect that changes to
+// dir or stops before running the rest of the command line.
+func (d Dialect) chdir(dir string) string {
+	switch d {
+	case Cmd:
+		return `cd /d ` + caretEscape(`"`+dir+`"`) + ` || exit 1 & `
+	case PowerShell:
+		return `Set-Location -LiteralPath ` + PowerShell.Quote(dir) + ` -ErrorAction Stop; `
+	default:
+		return `cd ` + POSIX.Quote(dir) + ` || exit; `
+	}
+}
+
+// posixSafe are the characters that never need quoting for a POSIX
+// shell.
+const posixSafe = `ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789_@%+=:,./-`
+
+// crtQuote quotes arg following the rules used by CommandLineToArgvW
+// and the Microsoft C runtime to split a command line into arguments.
+func crtQuote(arg string) string {
+	if len(arg) > 0 && !strings.ContainsAny(arg, " \t\n\v\"") {
+		return arg
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	slashes := 0
+	for _, r := range arg {
+		switch r {
+		case '\\':
+			slashes++
+			continue
+		case '"':
+			b.WriteString(strings.Repeat(`\`, 2*slashes+1))
+		default:
+			b.WriteString(strings.Repeat(`\`, slashes))
+		}
+		slashes = 0
+		b.WriteRune(r)
+	}
+	b.WriteString(strings.Repeat(`\`, 2*slashes))
+	b.WriteByte('"')
+	return b.String()
+}
+
+// caretEscape escapes every cmd.exe metacharacter (including double
+// quotes) in s with a caret so that cmd.exe passes them unchanged to
+// the command.
+func caretEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`()%!^"<>&|`, r) {
+			b.WriteByte('^')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// RunArgv is the same as [Client.Run] but takes the command and its
+// arguments as separate strings and joins them into a command line
+// quoted for the [Client.Dialect] of the target host (see
+// [Dialect.Join]) so that arguments containing spaces, quotes, or other
+// shell metacharacters are passed exactly as given. Returns
+// a [CommandLineMissing] error if argv is empty.
+func (c *Client) RunArgv(argv []string, stdin []byte, opts ...RunOption) (stdout, stderr string, err error) {
+	if len(argv) == 0 {
+		err = CommandLineMissing{}
+		return
+	}
+	return c.Run(c.Dialect.Join(argv...), stdin, opts...)
+}
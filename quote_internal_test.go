@@ -0,0 +1,118 @@
+package ssh
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// tricky contains the characters most likely to break out of quoting.
+const tricky = "it's \"q\" 100% a&b|c $HOME `id` !x"
+
+func TestDialect_setenv(t *testing.T) {
+	tests := []struct {
+		dialect Dialect
+		value   string
+		want    string
+	}{
+		{POSIX, `plain`, `export X=plain; `},
+		{POSIX, tricky, `export X='it'\''s "q" 100% a&b|c $HOME ` + "`id`" + ` !x'; `},
+		{POSIX, "a\nb", "export X='a\nb'; "},
+		{POSIX, ``, `export X=''; `},
+		{Cmd, `plain`, `set X=plain&`},
+		{Cmd, tricky, `set X=it's ^"q^" 100^% a^&b^|c $HOME ` + "`id`" + ` ^!x&`},
+		{Cmd, `%PATH%`, `set X=^%PATH^%&`},
+		{Cmd, `^<>()`, `set X=^^^<^>^(^)&`},
+		{PowerShell, `plain`, `$env:X=plain; `},
+		{PowerShell, tricky, `$env:X='it''s "q" 100% a&b|c $HOME ` + "`id`" + ` !x'; `},
+		{PowerShell, "a\nb", "$env:X='a\nb'; "},
+		{PowerShell, `‘’`, `$env:X='‘‘’’'; `},
+	}
+	for _, tt := range tests {
+		if got := tt.dialect.setenv(`X`, tt.value); got != tt.want {
+			t.Errorf("%v %q:\ngot  %s\nwant %s", tt.dialect, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestDialect_chdir(t *testing.T) {
+	tests := []struct {
+		dialect Dialect
+		dir     string
+		want    string
+	}{
+		{POSIX, `/tmp`, `cd /tmp || exit; `},
+		{POSIX, `~/it's here`, `cd '~/it'\''s here' || exit; `},
+		{POSIX, "$(id)\n;rm", "cd '$(id)\n;rm' || exit; "},
+		{Cmd, `C:\Program Files`, `cd /d ^"C:\Program Files^" || exit 1 & `},
+		{Cmd, `C:\a&b %x%`, `cd /d ^"C:\a^&b ^%x^%^" || exit 1 & `},
+		{PowerShell, `C:\it's`, `Set-Location -LiteralPath 'C:\it''s' -ErrorAction Stop; `},
+		{PowerShell, `$(id)`, `Set-Location -LiteralPath '$(id)' -ErrorAction Stop; `},
+	}
+	for _, tt := range tests {
+		if got := tt.dialect.chdir(tt.dir); got != tt.want {
+			t.Errorf("%v %q:\ngot  %s\nwant %s", tt.dialect, tt.dir, got, tt.want)
+		}
+	}
+}
+
+func TestRunConfig_prepare(t *testing.T) {
+	accept := func(name, value string) error { return nil }
+	refuse := func(name, value string) error { return errors.New(`refused`) }
+
+	// accepted variables are sent as requests only
+	var sent []string
+	cfg := newRunConfig([]RunOption{WithEnv(`A`, `1`), WithEnv(`B`, `x y`), WithEnv(`A`, `2`)})
+	got, err := cfg.prepare(func(name, value string) error {
+		sent = append(sent, name+`=`+value)
+		return nil
+	}, `env`, POSIX)
+	if err != nil || got != `env` || strings.Join(sent, `,`) != `A=2,B=x y` {
+		t.Fatalf(`got %q %v %v`, got, sent, err)
+	}
+
+	// refused variables fall back to the dialect syntax before the
+	// directory change
+	cfg = newRunConfig([]RunOption{WithEnv(`A`, `it's`), WithDir(`/a b`)})
+	for dialect, want := range map[Dialect]string{
+		POSIX:      `export A='it'\''s'; cd '/a b' || exit; env`,
+		Cmd:        `set A=it's&cd /d ^"/a b^" || exit 1 & env`,
+		PowerShell: `$env:A='it''s'; Set-Location -LiteralPath '/a b' -ErrorAction Stop; env`,
+	} {
+		got, err := cfg.prepare(refuse, `env`, dialect)
+		if err != nil || got != want {
+			t.Errorf("%v:\ngot  %s\nwant %s (%v)", dialect, got, want, err)
+		}
+	}
+
+	// becoming never sends requests
+	cfg = newRunConfig([]RunOption{WithEnv(`A`, `1`), WithBecome(Become{})})
+	got, err = cfg.prepare(func(string, string) error {
+		t.Fatal(`request sent while becoming`)
+		return nil
+	}, `env`, POSIX)
+	if err != nil || got != `export A=1; env` {
+		t.Fatalf(`got %q %v`, got, err)
+	}
+
+	// invalid names are refused before anything is sent
+	for _, name := range []string{`BAD NAME`, `1A`, `A;rm`, `A=B`, ``, "A\nB"} {
+		cfg = newRunConfig([]RunOption{WithEnv(name, `x`)})
+		if _, err := cfg.prepare(accept, `env`, POSIX); err == nil {
+			t.Errorf(`name %q accepted`, name)
+		}
+	}
+
+	// line breaks cannot be passed to cmd.exe
+	cfg = newRunConfig([]RunOption{WithEnv(`A`, "1\r\ndel x")})
+	if _, err := cfg.prepare(refuse, `env`, Cmd); err == nil {
+		t.Error(`line break in cmd value accepted`)
+	}
+	if _, err := cfg.prepare(accept, `env`, Cmd); err != nil {
+		t.Error(`line break refused when sent as a request:`, err)
+	}
+	cfg = newRunConfig([]RunOption{WithDir("C:\\\n&del x")})
+	if _, err := cfg.prepare(refuse, `env`, Cmd); err == nil {
+		t.Error(`line break in cmd dir accepted`)
+	}
+}
@@ -0,0 +1,41 @@
+package ssh_test
+
+import (
+	"fmt"
+
+	"github.com/rwxrob/ssh"
+)
+
+func ExampleDialect_Join() {
+
+	argv := []string{`grep`, `-r`, `it's here`, `$HOME/my notes`, `a&b`}
+
+	fmt.Println(ssh.POSIX.Join(argv...))
+	fmt.Println(ssh.Cmd.Join(argv...))
+	fmt.Println(ssh.PowerShell.Join(argv...))
+
+	// Output:
+	// grep -r 'it'\''s here' '$HOME/my notes' 'a&b'
+	// grep -r ^"it's here^" ^"$HOME/my notes^" a^&b
+	// & grep -r 'it''s here' '$HOME/my notes' 'a&b'
+
+}
+
+func ExampleDialect_Quote() {
+
+	fmt.Println(ssh.POSIX.Quote(``))
+	fmt.Println(ssh.POSIX.Quote(`/etc/hosts`))
+	fmt.Println(ssh.Cmd.Quote(`C:\Program Files\`))
+	fmt.Println(ssh.Cmd.Quote(`say "hi"`))
+	fmt.Println(ssh.Cmd.Quote(`100%`))
+	fmt.Println(ssh.PowerShell.Quote(`$env:PATH`))
+
+	// Output:
+	// ''
+	// /etc/hosts
+	// ^"C:\Program Files\\^"
+	// ^"say \^"hi\^"^"
+	// 100^%
+	// '$env:PATH'
+
+}
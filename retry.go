@@ -0,0 +1,141 @@
+package ssh
+
+import (
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultRetryPolicy is used by any Controller without a RetryPolicy of
+// its own. It fails over to every other connected client (once each)
+// when a command cannot be started because the connection to a client
+// fails but never runs a command again once it may have started.
+var DefaultRetryPolicy = RetryPolicy{}
+
+// ---------------------------- RetryPolicy ---------------------------
+
+// RetryPolicy decides if and when a command run on any client (see
+// [Controller.RunOnAny]) that fails is attempted again on another
+// client. A client is never attempted more than once for the same
+// command. Commands that could not be started because the connection to
+// the target host failed (which means the command never ran) are always
+// attempted again unless NoFailover is set, so the zero value fails over
+// (see [DefaultRetryPolicy]). A RetryPolicy may be safely
+// marshaled/unmarshaled to/from JSON/YAML.
+type RetryPolicy struct {
+
+	// MaxAttempts is the maximum number of attempts (including the
+	// first). If unset every connected client may be attempted.
+	MaxAttempts int
+
+	// Backoff is the delay before the second attempt which doubles
+	// before every attempt after it up to MaxBackoff (if set). If unset
+	// the next attempt is made immediately.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// NoFailover turns off attempting commands again that could not be
+	// started because the connection to the target host failed (which
+	// means the command never ran) and that are otherwise retried
+	// whatever else is set.
+	NoFailover bool
+
+	// Lost retries commands that lost their connection to the target
+	// host after being started but before completing (which means the
+	// command may or may not have run, in part or in full). Only enable
+	// for commands that are safe to run more than once.
+	Lost bool
+
+	// Timeout retries commands that were killed for running longer
+	// than their command timeout (see [CommandTimedOut]).
+	Timeout bool
+
+	// NonZeroExit retries commands exiting with any non-zero status (or
+	// because of a signal) and ExitCodes only those exiting with one of
+	// the listed statuses.
+	NonZeroExit bool
+	ExitCodes   []int
+}
+
+// retryable returns true if the error of r is one of the classes of
+// error retried by the policy.
+func (p RetryPolicy) retryable(r *Result) bool {
+	err := r.Err
+	var exit *ssh.ExitError
+	switch {
+	case err == nil:
+		return false
+	case connectionError(err) && r.unstarted:
+		return !p.NoFailover
+	case connectionError(err):
+		return p.Lost
+	case errors.As(err, new(CommandTimedOut)):
+		return p.Timeout
+	case errors.As(err, &exit):
+		if p.NonZeroExit {
+			return true
+		}
+		for _, code := range p.ExitCodes {
+			if code == r.ExitCode {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// backoff returns the delay before attempt n (starting from 0).
+func (p RetryPolicy) backoff(n int) time.Duration {
+	if n == 0 || p.Backoff <= 0 {
+		return 0
+	}
+	d := p.Backoff
+	for i := 1; i < n; i++ {
+		d *= 2
+		if p.MaxBackoff > 0 && d >= p.MaxBackoff {
+			return p.MaxBackoff
+		}
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		return p.MaxBackoff
+	}
+	return d
+}
+
+// connectionError returns true if err means the command could not be
+// started on or lost the connection to the target host.
+func connectionError(err error) bool {
+	var ope *net.OpError
+	var oce *ssh.OpenChannelError
+	var eme *ssh.ExitMissingError
+	return errors.As(err, &ope) || errors.As(err, &oce) ||
+		errors.As(err, &eme) || errors.Is(err, io.EOF)
+}
+
+// ------------------------------ Attempt -----------------------------
+
+// Attempt records a single attempt to run a command on a client (see
+// [Result.Attempts]).
+type Attempt struct {
+	Client   *Client
+	Start    time.Time
+	Duration time.Duration
+	Err      error
+}
+
+// disconnected marks the client as no longer connected and reconnects
+// it in a separate goroutine (which restores its connected status if
+// successful) unless its connection is still responding.
+func (c *Client) disconnected() {
+	sshclient := c.SSHClient()
+	if sshclient != nil && alive(sshclient) {
+		return
+	}
+	c.mu.Lock()
+	c.connected = false
+	c.mu.Unlock()
+	go c.reconnect(sshclient)
+}
@@ -0,0 +1,78 @@
+package ssh
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestRetryPolicy_retryable(t *testing.T) {
+	dial := &net.OpError{Op: `dial`, Net: `tcp`, Err: errors.New(`refused`)}
+	results := []*Result{
+		{},
+		notStarted(nil, dial),
+		notStarted(nil, io.EOF),
+		notStarted(nil, errors.New(`invalid environment variable name`)),
+		{ExitCode: -1, Err: &ssh.ExitMissingError{}},
+		{ExitCode: -1, Err: io.EOF},
+		{ExitCode: -1, Err: CommandTimedOut{Limit: time.Second}},
+		{ExitCode: 3, Err: &ssh.ExitError{}},
+		{ExitCode: 4, Err: &ssh.ExitError{}},
+	}
+	tests := []struct {
+		name   string
+		policy RetryPolicy
+		want   string // one y or n for each of the results
+	}{
+		{`default`, DefaultRetryPolicy, `nyynnnnnn`},
+		{`zero value`, RetryPolicy{}, `nyynnnnnn`},
+		{`no failover`, RetryPolicy{NoFailover: true}, `nnnnnnnnn`},
+		{`lost`, RetryPolicy{Lost: true}, `nyynyynnn`},
+		{`lost only`, RetryPolicy{NoFailover: true, Lost: true}, `nnnnyynnn`},
+		{`timeout`, RetryPolicy{NoFailover: true, Timeout: true}, `nnnnnnynn`},
+		{`exit codes`, RetryPolicy{NoFailover: true, ExitCodes: []int{3}}, `nnnnnnnyn`},
+		{`non-zero exit`, RetryPolicy{NoFailover: true, NonZeroExit: true}, `nnnnnnnyy`},
+	}
+	for _, tt := range tests {
+		var got []byte
+		for _, r := range results {
+			if tt.policy.retryable(r) {
+				got = append(got, 'y')
+			} else {
+				got = append(got, 'n')
+			}
+		}
+		if string(got) != tt.want {
+			t.Errorf(`%v: got %s want %s`, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRetryPolicy_backoff(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy RetryPolicy
+		n      int
+		want   time.Duration
+	}{
+		{`first attempt`, RetryPolicy{Backoff: time.Second}, 0, 0},
+		{`second attempt`, RetryPolicy{Backoff: time.Second}, 1, time.Second},
+		{`doubles`, RetryPolicy{Backoff: time.Second}, 2, 2 * time.Second},
+		{`doubles again`, RetryPolicy{Backoff: time.Second}, 4, 8 * time.Second},
+		{`under max`, RetryPolicy{Backoff: time.Second, MaxBackoff: 5 * time.Second}, 3, 4 * time.Second},
+		{`capped`, RetryPolicy{Backoff: time.Second, MaxBackoff: 5 * time.Second}, 4, 5 * time.Second},
+		{`capped far out`, RetryPolicy{Backoff: time.Second, MaxBackoff: 5 * time.Second}, 100, 5 * time.Second},
+		{`max below backoff`, RetryPolicy{Backoff: time.Second, MaxBackoff: 500 * time.Millisecond}, 1, 500 * time.Millisecond},
+		{`max below backoff far out`, RetryPolicy{Backoff: time.Second, MaxBackoff: 500 * time.Millisecond}, 100, 500 * time.Millisecond},
+		{`no backoff`, RetryPolicy{MaxBackoff: time.Second}, 3, 0},
+	}
+	for _, tt := range tests {
+		if got := tt.policy.backoff(tt.n); got != tt.want {
+			t.Errorf(`%v: backoff(%v) got %v want %v`, tt.name, tt.n, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package ssh
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"strings"
+)
+
+// DefaultInterpreter is used for scripts without a shebang line (see
+// [NewScript]).
+var DefaultInterpreter = `sh -s --`
+
+// ------------------------------ Script ------------------------------
+
+// Script is a local script run on the target host by streaming its Body
+// to the standard input of an interpreter so that it never has to be
+// copied there first. The command line that does this (see
+// [Script.Command]) and the Body (as bytes) can be passed as the cmd
+// and stdin of any method that runs commands so scripts work with
+// RunOnAny and every other Controller method. A Script may be safely
+// marshaled/unmarshaled to/from JSON/YAML.
+type Script struct {
+
+	// Interpreter is the command line that runs a script read from its
+	// standard input and accepts the script arguments after it (ex:
+	// bash -s --, python3 -).
+	Interpreter string
+
+	// Body is the content of the script (a string so that it reads
+	// naturally as a YAML block scalar).
+	Body string
+
+	// Args are passed to the script (each quoted, see [Dialect.Quote]).
+	Args []string
+}
+
+// NewScript returns a Script with the Interpreter derived from the
+// shebang line (#!) of body, if any, or DefaultInterpreter if not.
+// Shells (sh, bash, zsh, etc.) are passed -s -- so that they read the
+// script from standard input and everything else (python3, perl, ruby,
+// node, etc.) is passed a single dash (-) which is the convention for
+// reading a script from standard input. Both are passed any options
+// from the shebang line first. An interpreter run with /usr/bin/env is
+// looked up in the PATH of the target host.
+//
+//	#!/bin/bash -e           -> /bin/bash -e -s --
+//	#!/usr/bin/env python3   -> python3 -
+func NewScript(body []byte, args ...string) *Script {
+	return &Script{Interpreter: interpreter(body), Body: string(body), Args: args}
+}
+
+// ReadScript reads the local file at path and returns a new Script for
+// it (see [NewScript]).
+func ReadScript(path string, args ...string) (*Script, error) {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewScript(body, args...), nil
+}
+
+// Command returns the Interpreter followed by the POSIX quoted Args.
+func (s *Script) Command() string { return s.command(POSIX) }
+
+func (s *Script) command(dialect Dialect) string {
+	line := s.Interpreter
+	if len(line) == 0 {
+		line = DefaultInterpreter
+	}
+	for _, arg := range s.Args {
+		line += ` ` + dialect.Quote(arg)
+	}
+	return line
+}
+
+// interpreter returns the interpreter command line from the shebang
+// line of body.
+func interpreter(body []byte) string {
+	if !bytes.HasPrefix(body, []byte(`#!`)) {
+		return DefaultInterpreter
+	}
+	line, _, _ := bytes.Cut(body[2:], []byte("\n"))
+	fields := strings.Fields(string(line))
+	if len(fields) == 0 {
+		return DefaultInterpreter
+	}
+	if path.Base(fields[0]) == `env` && len(fields) > 1 {
+		fields = fields[1:]
+	}
+	switch strings.TrimRight(path.Base(fields[0]), `0123456789.`) {
+	case `sh`, `bash`, `dash`, `ash`, `zsh`, `ksh`, `mksh`, `yash`:
+		fields = append(fields, `-s`, `--`)
+	default:
+		fields = append(fields, `-`)
+	}
+	for i, f := range fields {
+		fields[i] = POSIX.Quote(f)
+	}
+	return strings.Join(fields, ` `)
+}
+
+// RunScript runs the Script on the target host streaming its Body to
+// the standard input of its Interpreter with [Client.Run].
+func (c *Client) RunScript(s *Script, opts ...RunOption) (stdout, stderr string, err error) {
+	return c.Run(s.command(c.Dialect), []byte(s.Body), opts...)
+}
+
+// RunScriptOnAny runs the Script on a random client with
+// [Controller.RunOnAny] quoting the Args in the Dialect of whichever
+// client is chosen.
+func (c *Controller) RunScriptOnAny(s *Script, opts ...RunOption) (stdout, stderr string, err error) {
+	return c.RunOnAny(s.Command(), []byte(s.Body), append(opts, withScript(s))...)
+}
+
+// withScript replaces the command with the command line of the Script
+// (see [Script.Command]) in the Dialect of the client it runs on.
+func withScript(s *Script) RunOption {
+	return func(c *runConfig) { c.script = s }
+}
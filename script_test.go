@@ -0,0 +1,59 @@
+package ssh_test
+
+import (
+	"fmt"
+
+	"github.com/rwxrob/ssh"
+	"gopkg.in/yaml.v3"
+)
+
+func ExampleNewScript() {
+
+	bash := ssh.NewScript([]byte("#!/bin/bash -e\necho \"$1\"\n"), `it's`, `--help`)
+	fmt.Println(bash.Command())
+
+	py := ssh.NewScript([]byte("#!/usr/bin/env python3\nimport sys\nprint(sys.argv)\n"), `a b`)
+	fmt.Println(py.Command())
+
+	plain := ssh.NewScript([]byte("echo hello\n"))
+	fmt.Println(plain.Command())
+
+	// Output:
+	// /bin/bash -e -s -- 'it'\''s' --help
+	// python3 - 'a b'
+	// sh -s --
+
+}
+
+func ExampleScript_yaml() {
+
+	yml := []byte(`
+interpreter: bash -s --
+body: |
+  set -e
+  echo "$1"
+args: [it's]
+`)
+
+	s := new(ssh.Script)
+	if err := yaml.Unmarshal(yml, s); err != nil {
+		fmt.Println(err)
+	}
+	fmt.Println(s.Command())
+	fmt.Print(s.Body)
+
+	out, _ := yaml.Marshal(s)
+	fmt.Print(string(out))
+
+	// Output:
+	// bash -s -- 'it'\''s'
+	// set -e
+	// echo "$1"
+	// interpreter: bash -s --
+	// body: |
+	//     set -e
+	//     echo "$1"
+	// args:
+	//     - it's
+
+}
@@ -0,0 +1,330 @@
+package ssh
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultLatencySmoothing is the weight (between 0 and 1) given to each
+// new run time observed for a client in its exponentially weighted
+// moving average latency (see [Client.Latency]).
+var DefaultLatencySmoothing = 0.2
+
+// DefaultReplicas is the number of points on the hash ring for each
+// client used by a ConsistentHash selector with no Replicas set.
+var DefaultReplicas = 100
+
+// WithKey sets the key used by selectors that choose a client by key
+// (see [ConsistentHash] and [KeyedSelector]) so that commands with the
+// same key keep going to the same client for as long as it remains
+// available, even when it is busy (see [Client.MaxSessions]). Ignored
+// by all other selectors and when running on a specific Client.
+func WithKey(key string) RunOption {
+	return func(c *runConfig) { c.key = key }
+}
+
+// WithAffinity sends commands run on any client (see
+// [Controller.RunOnAny]) with the same key to the same client for as
+// long as it remains available (connected, healthy, and with a closed
+// circuit) using [Rendezvous] hashing no matter what the Selector of
+// the Controller is. When that client is unavailable (or a retry is
+// needed) the client with the next highest score for the key is used
+// so only the keys of unavailable clients ever move. A client that is
+// busy (see [Client.MaxSessions]) is still available and the command
+// waits for one of its sessions to close.
+func WithAffinity(key string) RunOption {
+	return func(c *runConfig) {
+		c.key = key
+		c.affinity = true
+	}
+}
+
+// InFlight returns the number of commands currently running on the
+// client.
+func (c *Client) InFlight() int { return int(c.inflight.Load()) }
+
+// Latency returns the exponentially weighted moving average of the time
+// taken by commands run on the client (see [DefaultLatencySmoothing]) or
+// zero if none have completed yet.
+func (c *Client) Latency() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.latency
+}
+
+// observe adds the run time of a completed command to the Latency.
+func (c *Client) observe(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.latency == 0 {
+		c.latency = d
+		return
+	}
+	c.latency += time.Duration(DefaultLatencySmoothing * float64(d-c.latency))
+}
+
+// ----------------------------- Selector -----------------------------
+
+// Selector chooses the client on which to run the next command from the
+// candidates given, which are the connected Clients of a Controller (in
+// order) that have not already been tried for the same command. The key
+// is the one set with [WithKey] (if any). Select must return one of the
+// candidates or nil if none are acceptable and must be safe to call
+// concurrently.
+type Selector interface {
+	Select(candidates []*Client, key string) *Client
+}
+
+// KeyedSelector is a Selector that chooses a client by key (such as
+// ConsistentHash and Rendezvous). Commands with a key (see [WithKey])
+// are only given the candidates with a free session slot (see
+// [Client.MaxSessions]) when Keyed returns false. Otherwise all
+// candidates are given, even those that are busy, so that the key does
+// not move to another client and the command waits for a slot instead.
+type KeyedSelector interface {
+	Selector
+	Keyed() bool
+}
+
+// Random selects a random client (the default). Rand is the source of
+// random numbers (optional, ex: rand.New(rand.NewSource(1)) for
+// a repeatable sequence) or the global source of math/rand if nil.
+// Since a rand.Rand is not safe for concurrent use it is only used
+// while holding a lock shared by every selector and must not be used
+// anywhere else.
+type Random struct {
+	Rand *rand.Rand
+}
+
+func (s Random) Select(candidates []*Client, key string) *Client {
+	if len(candidates) == 0 {
+		return nil
+	}
+	return candidates[intn(s.Rand, len(candidates))]
+}
+
+var randmu sync.Mutex
+
+// intn returns a random number in [0,n) from r or the global source if
+// r is nil.
+func intn(r *rand.Rand, n int) int {
+	if r == nil {
+		return rand.Intn(n)
+	}
+	randmu.Lock()
+	defer randmu.Unlock()
+	return r.Intn(n)
+}
+
+// RoundRobin selects each client in turn.
+type RoundRobin struct {
+	next atomic.Uint64
+}
+
+func (s *RoundRobin) Select(candidates []*Client, key string) *Client {
+	if len(candidates) == 0 {
+		return nil
+	}
+	n := s.next.Add(1) - 1
+	return candidates[n%uint64(len(candidates))]
+}
+
+// LeastInFlight selects the client with the fewest commands currently
+// running (see [Client.InFlight]) with ties going to the first in
+// order.
+type LeastInFlight struct{}
+
+func (LeastInFlight) Select(candidates []*Client, key string) *Client {
+	var best *Client
+	var min int
+	for _, client := range candidates {
+		if n := client.InFlight(); best == nil || n < min {
+			best, min = client, n
+		}
+	}
+	return best
+}
+
+// Weighted selects a random client with the probability of each in
+// proportion to its [Client.Weight]. Clients with a negative weight
+// are never selected. Rand is the same as for [Random].
+type Weighted struct {
+	Rand *rand.Rand
+}
+
+func (s Weighted) Select(candidates []*Client, key string) *Client {
+	var total int
+	for _, client := range candidates {
+		total += weight(client)
+	}
+	if total <= 0 {
+		return nil
+	}
+	n := intn(s.Rand, total)
+	for _, client := range candidates {
+		if n -= weight(client); n < 0 {
+			return client
+		}
+	}
+	return nil
+}
+
+func weight(c *Client) int {
+	switch {
+	case c.Weight < 0:
+		return 0
+	case c.Weight == 0:
+		return 1
+	}
+	return c.Weight
+}
+
+// LeastLatency selects the client with the lowest observed latency (see
+// [Client.Latency]). Clients without any observed run times are
+// selected first so that every client gets measured.
+type LeastLatency struct{}
+
+func (LeastLatency) Select(candidates []*Client, key string) *Client {
+	var best *Client
+	var min time.Duration
+	for _, client := range candidates {
+		l := client.Latency()
+		if l == 0 {
+			return client
+		}
+		if best == nil || l < min {
+			best, min = client, l
+		}
+	}
+	return best
+}
+
+// ConsistentHash selects the client owning the key (see [WithKey]) on
+// a hash ring of all the candidates so that the same key keeps
+// selecting the same client and only the keys of a client that becomes
+// unavailable move to others. Commands without a key are sent to
+// a random client. Replicas is the number of points on the ring for
+// each client (DefaultReplicas if unset). The ring of each set of
+// candidates is built once and cached (see [ConsistentHash.Reset]).
+type ConsistentHash struct {
+	Replicas int
+
+	mu    sync.Mutex
+	rings map[string][]point
+}
+
+type point struct {
+	hash   uint64
+	client *Client
+}
+
+// maxRings is the number of rings (one for each set of candidates)
+// cached by a ConsistentHash before they are all discarded.
+const maxRings = 16
+
+// Keyed returns true (see [KeyedSelector]).
+func (s *ConsistentHash) Keyed() bool { return true }
+
+func (s *ConsistentHash) Select(candidates []*Client, key string) *Client {
+	if len(candidates) == 0 {
+		return nil
+	}
+	if len(key) == 0 {
+		return Random{}.Select(candidates, key)
+	}
+	ring := s.ring(candidates)
+	h := hash(key)
+	i := sort.Search(len(ring), func(i int) bool { return ring[i].hash >= h })
+	if i == len(ring) {
+		i = 0
+	}
+	return ring[i].client
+}
+
+// Reset discards the cached rings, which is only needed if the Dest of
+// a client (see [Client.Dest]) or the Replicas change.
+func (s *ConsistentHash) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rings = nil
+}
+
+// ring returns the (cached) hash ring of the candidates.
+func (s *ConsistentHash) ring(candidates []*Client) []point {
+	var id strings.Builder
+	for _, client := range candidates {
+		fmt.Fprintf(&id, `%p,`, client)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ring, has := s.rings[id.String()]; has {
+		return ring
+	}
+	replicas := s.Replicas
+	if replicas <= 0 {
+		replicas = DefaultReplicas
+	}
+	ring := make([]point, 0, len(candidates)*replicas)
+	for _, client := range candidates {
+		dest := client.Dest()
+		for i := 0; i < replicas; i++ {
+			ring = append(ring, point{hash(dest + `#` + strconv.Itoa(i)), client})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i].hash < ring[j].hash })
+	if s.rings == nil || len(s.rings) >= maxRings {
+		s.rings = map[string][]point{}
+	}
+	s.rings[id.String()] = ring
+	return ring
+}
+
+// Rendezvous selects the client with the highest score for the key
+// (see [WithKey]) using rendezvous (highest random weight) hashing so
+// that the same key keeps selecting the same client and only the keys
+// of a client that becomes unavailable move (each to the client with
+// the next highest score). Unlike ConsistentHash no ring is needed and
+// keys are spread evenly over any number of clients. Commands without
+// a key are sent to a random client. Also see [WithAffinity].
+type Rendezvous struct{}
+
+// Keyed returns true (see [KeyedSelector]).
+func (Rendezvous) Keyed() bool { return true }
+
+func (Rendezvous) Select(candidates []*Client, key string) *Client {
+	if len(key) == 0 {
+		return Random{}.Select(candidates, key)
+	}
+	var best *Client
+	var max uint64
+	for _, client := range candidates {
+		if score := mix(hash(key + "\x00" + client.Dest())); best == nil || score > max {
+			best, max = client, score
+		}
+	}
+	return best
+}
+
+// mix is the 64-bit finalizer of MurmurHash3 which spreads the bits of
+// similar hashes apart.
+func mix(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+func hash(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return h.Sum64()
+}
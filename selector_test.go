@@ -0,0 +1,102 @@
+package ssh_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/rwxrob/ssh"
+)
+
+func ExampleRoundRobin() {
+	clients := []*ssh.Client{
+		{Host: &ssh.Host{Addr: `a`}},
+		{Host: &ssh.Host{Addr: `b`}},
+		{Host: &ssh.Host{Addr: `c`}},
+	}
+	s := new(ssh.RoundRobin)
+	for i := 0; i < 4; i++ {
+		fmt.Println(s.Select(clients, ``).Host.Addr)
+	}
+	// Output:
+	// a
+	// b
+	// c
+	// a
+}
+
+func ExampleConsistentHash() {
+	clients := []*ssh.Client{
+		{Host: &ssh.Host{Addr: `a`}},
+		{Host: &ssh.Host{Addr: `b`}},
+		{Host: &ssh.Host{Addr: `c`}},
+	}
+	s := new(ssh.ConsistentHash)
+	owner := s.Select(clients, `user42`)
+	fmt.Println(owner == s.Select(clients, `user42`))
+
+	// removing any other client does not move the key
+	var others []*ssh.Client
+	for _, c := range clients {
+		if c == owner || len(others) == 0 {
+			others = append(others, c)
+		}
+	}
+	fmt.Println(owner == s.Select(others, `user42`))
+
+	// Output:
+	// true
+	// true
+}
+
+func TestRendezvous(t *testing.T) {
+	var clients []*ssh.Client
+	for _, addr := range []string{`a`, `b`, `c`, `d`, `e`} {
+		clients = append(clients, &ssh.Client{Host: &ssh.Host{Addr: addr}})
+	}
+	var s ssh.Rendezvous
+	owners := map[string]*ssh.Client{}
+	counts := map[*ssh.Client]int{}
+	for i := 0; i < 500; i++ {
+		key := fmt.Sprint(`key`, i)
+		owners[key] = s.Select(clients, key)
+		counts[owners[key]]++
+	}
+
+	// every client owns some of the keys
+	for _, c := range clients {
+		if counts[c] == 0 {
+			t.Fatalf(`%v owns no keys`, c.Host.Addr)
+		}
+	}
+
+	// the order of the candidates makes no difference
+	reversed := make([]*ssh.Client, len(clients))
+	for i, c := range clients {
+		reversed[len(clients)-1-i] = c
+	}
+	for key, owner := range owners {
+		if s.Select(reversed, key) != owner {
+			t.Fatalf(`%v moved when reordered`, key)
+		}
+	}
+
+	// a key moves only when its owner is excluded
+	for _, excluded := range clients {
+		var rest []*ssh.Client
+		for _, c := range clients {
+			if c != excluded {
+				rest = append(rest, c)
+			}
+		}
+		for key, owner := range owners {
+			got := s.Select(rest, key)
+			switch {
+			case got == excluded:
+				t.Fatalf(`%v selected excluded %v`, key, excluded.Host.Addr)
+			case owner != excluded && got != owner:
+				t.Fatalf(`%v moved from %v to %v without %v`, key,
+					owner.Host.Addr, got.Host.Addr, excluded.Host.Addr)
+			}
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package ssh
+
+// DefaultMaxSessions is the number of sessions a Client may have open
+// at once when it has no MaxSessions of its own, which matches the
+// default MaxSessions of the OpenSSH server.
+var DefaultMaxSessions = 10
+
+// ------------------------------ Sessions ----------------------------
+
+// slots returns the semaphore limiting the open sessions of the client
+// (created on first use) or nil if unlimited.
+func (c *Client) slots() chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.sessions == nil {
+		max := c.MaxSessions
+		if max == 0 {
+			max = DefaultMaxSessions
+		}
+		if max < 0 {
+			return nil
+		}
+		c.sessions = make(chan struct{}, max)
+	}
+	return c.sessions
+}
+
+// semaphore returns the same as slots without creating it, which is nil
+// until the first command is run (or if unlimited) so that MaxSessions
+// may still be changed until then.
+func (c *Client) semaphore() chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sessions
+}
+
+// acquire waits for a free session slot and returns the function that
+// frees it again.
+func (c *Client) acquire() (release func()) {
+	slots := c.slots()
+	if slots == nil {
+		return func() {}
+	}
+	select {
+	case slots <- struct{}{}:
+	default:
+		c.queued.Add(1)
+		slots <- struct{}{}
+		c.queued.Add(-1)
+	}
+	return func() { <-slots }
+}
+
+// Sessions returns the number of sessions currently open (or being
+// opened) on the client, which never exceeds its MaxSessions.
+func (c *Client) Sessions() int { return len(c.semaphore()) }
+
+// Queued returns the number of commands waiting for a session slot of
+// the client to be freed before they can start.
+func (c *Client) Queued() int { return int(c.queued.Load()) }
+
+// full returns true if the client has no free session slots.
+func (c *Client) full() bool {
+	slots := c.semaphore()
+	return slots != nil && len(slots) >= cap(slots)
+}
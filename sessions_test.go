@@ -0,0 +1,78 @@
+package ssh
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestClient_slots(t *testing.T) {
+	c := &Client{MaxSessions: 1}
+
+	// looking does not fix the number of slots
+	if c.Sessions() != 0 || c.full() {
+		t.Fatal(`busy before any command`)
+	}
+	c.MaxSessions = 2
+	release := c.acquire()
+	if c.Sessions() != 1 || c.full() {
+		t.Fatal(c.Sessions(), c.full())
+	}
+	c.acquire()
+	if c.Sessions() != 2 || !c.full() {
+		t.Fatal(c.Sessions(), c.full())
+	}
+
+	// but the first command does
+	c.MaxSessions = 3
+	release()
+	c.acquire()
+	if c.Sessions() != 2 || !c.full() {
+		t.Fatal(c.Sessions(), c.full())
+	}
+}
+
+// unkeyed is a Rendezvous selector that opts out of keeping keys on
+// busy clients.
+type unkeyed struct{ Rendezvous }
+
+func (unkeyed) Keyed() bool { return false }
+
+func TestController_selectClient_spill(t *testing.T) {
+	var clients []*Client
+	for _, addr := range []string{`a`, `b`} {
+		clients = append(clients, &Client{Host: &Host{Addr: addr}, MaxSessions: 1, connected: true})
+	}
+	busy, free := clients[0], clients[1]
+	busy.acquire()
+
+	// a key of the busy client for both keyed selectors
+	hash := new(ConsistentHash)
+	var key string
+	for i := 0; len(key) == 0; i++ {
+		k := fmt.Sprint(`key`, i)
+		if hash.Select(clients, k) == busy && (Rendezvous{}).Select(clients, k) == busy {
+			key = k
+		}
+	}
+
+	tests := []struct {
+		name     string
+		selector Selector
+		opts     []RunOption
+		want     *Client
+	}{
+		{`consistent hash`, hash, []RunOption{WithKey(key)}, busy},
+		{`rendezvous`, Rendezvous{}, []RunOption{WithKey(key)}, busy},
+		{`affinity`, first{}, []RunOption{WithAffinity(key)}, busy},
+		{`no key`, Rendezvous{}, nil, free},
+		{`not keyed`, first{}, []RunOption{WithKey(key)}, free},
+		{`opted out`, unkeyed{}, []RunOption{WithKey(key)}, free},
+	}
+	for _, tt := range tests {
+		ctl := &Controller{Clients: clients, Selector: tt.selector}
+		got := ctl.selectClient(nil, newRunConfig(tt.opts))
+		if got != tt.want {
+			t.Errorf(`%v: got %v want %v`, tt.name, got.Host.Addr, tt.want.Host.Addr)
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package ssh
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultSOCKSTimeout is the time allowed for a local SOCKS client to
+// complete the negotiation of a dynamic forward (see
+// [Client.ForwardDynamic]) before its connection is closed.
+var DefaultSOCKSTimeout = 10 * time.Second
+
+// ------------------------ Dynamic Forwarding ------------------------
+
+// SOCKS contains the optional settings of a dynamic forward (see
+// [Client.ForwardDynamic]). A SOCKS may be safely marshaled/unmarshaled
+// to/from JSON/YAML.
+type SOCKS struct {
+
+	// Username and Password required from local SOCKS clients
+	// (optional). When Username is empty no authentication is required.
+	Username string
+	Password string
+
+	// Allow is a list of destination patterns that are permitted
+	// (optional). When empty all destinations not matching Deny are
+	// permitted. See [SOCKS.Permits] for the pattern syntax.
+	Allow []string
+
+	// Deny is a list of destination patterns that are always refused
+	// (optional) even when also matching Allow.
+	Deny []string
+}
+
+// Permits returns true if the destination host and port is allowed by
+// the Allow and Deny rules. Each rule is a host pattern optionally
+// followed by a colon and port (ex: *.internal:443, 10.0.0.0/8,
+// [::1]:22). The host pattern may be a CIDR network, which only ever
+// matches IP destinations, or a shell glob pattern (see [path.Match])
+// which is matched against the destination as given by the local
+// SOCKS client (domain names are resolved on the target host and never
+// locally). A missing port matches any port.
+func (s *SOCKS) Permits(host string, port int) bool {
+	for _, rule := range s.Deny {
+		if socksMatch(rule, host, port) {
+			return false
+		}
+	}
+	if len(s.Allow) == 0 {
+		return true
+	}
+	for _, rule := range s.Allow {
+		if socksMatch(rule, host, port) {
+			return true
+		}
+	}
+	return false
+}
+
+func socksMatch(rule, host string, port int) bool {
+	pattern := rule
+	if h, p, err := net.SplitHostPort(rule); err == nil {
+		if n, err := strconv.Atoi(p); err != nil || n != port {
+			return false
+		}
+		pattern = h
+	}
+	if _, network, err := net.ParseCIDR(pattern); err == nil {
+		ip := net.ParseIP(host)
+		return ip != nil && network.Contains(ip)
+	}
+	matched, _ := path.Match(strings.ToLower(pattern), strings.ToLower(host))
+	return matched
+}
+
+// ForwardDynamic is the equivalent of ssh -D. It listens on the local
+// laddr (lnet "tcp" or "unix") as a SOCKS5 server and forwards every
+// CONNECT request through the SSH connection of the Client to the IPv4,
+// IPv6, or domain name destination requested as seen from the target
+// host. The settings may be nil to accept any destination without
+// authentication. If the Client has not yet connected [Client.Connect]
+// is called first. Like [Client.ForwardLocal] forwarded connections
+// survive the Client reconnecting.
+func (c *Client) ForwardDynamic(lnet, laddr string, settings *SOCKS) (*Forward, error) {
+	if settings == nil {
+		settings = new(SOCKS)
+	}
+	if _, err := c.connection(); err != nil {
+		return nil, err
+	}
+	l, err := net.Listen(lnet, laddr)
+	if err != nil {
+		return nil, err
+	}
+	return newForward(l, func(local net.Conn) (net.Conn, error) {
+		return settings.handshake(local, c.Dial)
+	}), nil
+}
+
+// SOCKS5 protocol values from RFC 1928 and RFC 1929.
+const (
+	socksVersion      = 5
+	socksNoAuth       = 0
+	socksUserPass     = 2
+	socksNoAcceptable = 0xff
+	socksConnect      = 1
+	socksIPv4         = 1
+	socksDomain       = 3
+	socksIPv6         = 4
+
+	socksSucceeded        = 0
+	socksNotAllowed       = 2
+	socksHostUnreachable  = 4
+	socksCmdNotSupported  = 7
+	socksAddrNotSupported = 8
+
+	socksUserPassVersion   = 1
+	socksUserPassSucceeded = 0
+	socksUserPassFailed    = 1
+)
+
+var errSOCKS = errors.New(`SOCKS5 handshake failed`)
+
+// handshake negotiates a SOCKS5 CONNECT with the local connection and
+// returns the remote connection created with dial once the reply has
+// been sent. The negotiation must complete within DefaultSOCKSTimeout.
+func (s *SOCKS) handshake(local net.Conn, dial func(network, addr string) (net.Conn, error)) (net.Conn, error) {
+	local.SetDeadline(time.Now().Add(DefaultSOCKSTimeout))
+	defer local.SetDeadline(time.Time{})
+
+	// greeting
+	buf := make([]byte, 256)
+	if _, err := io.ReadFull(local, buf[:2]); err != nil {
+		return nil, err
+	}
+	if buf[0] != socksVersion {
+		return nil, errSOCKS
+	}
+	methods := buf[:buf[1]]
+	if _, err := io.ReadFull(local, methods); err != nil {
+		return nil, err
+	}
+	want := byte(socksNoAuth)
+	if len(s.Username) > 0 {
+		want = socksUserPass
+	}
+	if !containsByte(methods, want) {
+		local.Write([]byte{socksVersion, socksNoAcceptable})
+		return nil, errSOCKS
+	}
+	if _, err := local.Write([]byte{socksVersion, want}); err != nil {
+		return nil, err
+	}
+	if want == socksUserPass {
+		if err := s.authenticate(local); err != nil {
+			return nil, err
+		}
+	}
+
+	// request
+	if _, err := io.ReadFull(local, buf[:4]); err != nil {
+		return nil, err
+	}
+	if buf[0] != socksVersion {
+		return nil, errSOCKS
+	}
+	cmd, atyp := buf[1], buf[3]
+	var host string
+	switch atyp {
+	case socksIPv4, socksIPv6:
+		ip := make(net.IP, 4)
+		if atyp == socksIPv6 {
+			ip = make(net.IP, 16)
+		}
+		if _, err := io.ReadFull(local, ip); err != nil {
+			return nil, err
+		}
+		host = ip.String()
+	case socksDomain:
+		if _, err := io.ReadFull(local, buf[:1]); err != nil {
+			return nil, err
+		}
+		name := buf[:buf[0]]
+		if _, err := io.ReadFull(local, name); err != nil {
+			return nil, err
+		}
+		host = string(name)
+	default:
+		socksReply(local, socksAddrNotSupported)
+		return nil, errSOCKS
+	}
+	if _, err := io.ReadFull(local, buf[:2]); err != nil {
+		return nil, err
+	}
+	port := int(binary.BigEndian.Uint16(buf[:2]))
+	if cmd != socksConnect {
+		socksReply(local, socksCmdNotSupported)
+		return nil, errSOCKS
+	}
+	if !s.Permits(host, port) {
+		socksReply(local, socksNotAllowed)
+		return nil, fmt.Errorf(`SOCKS5 destination not allowed: %v`, net.JoinHostPort(host, strconv.Itoa(port)))
+	}
+
+	remote, err := dial(`tcp`, net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		socksReply(local, socksHostUnreachable)
+		return nil, err
+	}
+	if err := socksReply(local, socksSucceeded); err != nil {
+		remote.Close()
+		return nil, err
+	}
+	return remote, nil
+}
+
+// authenticate performs the username/password subnegotiation.
+func (s *SOCKS) authenticate(local net.Conn) error {
+	buf := make([]byte, 256)
+	if _, err := io.ReadFull(local, buf[:2]); err != nil {
+		return err
+	}
+	if buf[0] != socksUserPassVersion {
+		return errSOCKS
+	}
+	user := make([]byte, buf[1])
+	if _, err := io.ReadFull(local, user); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(local, buf[:1]); err != nil {
+		return err
+	}
+	pass := make([]byte, buf[0])
+	if _, err := io.ReadFull(local, pass); err != nil {
+		return err
+	}
+	userok := subtle.ConstantTimeCompare(user, []byte(s.Username))
+	passok := subtle.ConstantTimeCompare(pass, []byte(s.Password))
+	if userok&passok != 1 {
+		local.Write([]byte{socksUserPassVersion, socksUserPassFailed})
+		return errSOCKS
+	}
+	_, err := local.Write([]byte{socksUserPassVersion, socksUserPassSucceeded})
+	return err
+}
+
+// socksReply writes a reply with the given code and an unspecified
+// bound address (which clients are not expected to use).
+func socksReply(local net.Conn, code byte) error {
+	_, err := local.Write([]byte{socksVersion, code, 0, socksIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+func containsByte(list []byte, b byte) bool {
+	for _, v := range list {
+		if v == b {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,33 @@
+package ssh_test
+
+import (
+	"fmt"
+
+	"github.com/rwxrob/ssh"
+)
+
+func ExampleSOCKS_Permits() {
+
+	socks := &ssh.SOCKS{
+		Allow: []string{`*.internal`, `10.0.0.0/8`, `db.example.com:5432`},
+		Deny:  []string{`secret.internal`, `10.0.0.1`},
+	}
+
+	fmt.Println(socks.Permits(`grafana.internal`, 443))
+	fmt.Println(socks.Permits(`secret.internal`, 443))
+	fmt.Println(socks.Permits(`10.1.2.3`, 22))
+	fmt.Println(socks.Permits(`10.0.0.1`, 22))
+	fmt.Println(socks.Permits(`db.example.com`, 5432))
+	fmt.Println(socks.Permits(`db.example.com`, 22))
+	fmt.Println(socks.Permits(`example.com`, 80))
+
+	// Output:
+	// true
+	// false
+	// true
+	// false
+	// true
+	// false
+	// false
+
+}
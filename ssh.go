@@ -6,12 +6,11 @@ coordinate operations on multiple targets.
 package ssh
 
 import (
-	"bytes"
 	"fmt"
 	"log"
-	"math/rand"
-	"net"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/crypto/ssh"
@@ -34,6 +33,21 @@ type CommandLineMissing struct{}
 
 func (CommandLineMissing) Error() string { return `missing argument containing command line` }
 
+// CommandTimedOut is returned when a command runs longer than its
+// command timeout (see [WithTimeout]) and has been killed. It is never
+// returned for a TCP connection timeout (see [Client.Timeout]).
+type CommandTimedOut struct {
+	Dest  string
+	Limit time.Duration
+}
+
+func (e CommandTimedOut) Error() string {
+	return fmt.Sprintf(`command timed out after %v on %v`, e.Limit, e.Dest)
+}
+
+// Timeout always returns true (see [net.Error]).
+func (CommandTimedOut) Timeout() bool { return true }
+
 // ------------------------------- User -------------------------------
 
 // User represents a single SSH user on the target host authenticated by
@@ -118,27 +132,89 @@ type Client struct {
 	// connection. If unset DefaultTCPTimeout is used.
 	Timeout time.Duration
 
+	// CommandTimeout is the default maximum time a command may run
+	// before it is killed and CommandTimedOut returned. If unset the
+	// CommandTimeout of the Controller running the command is used (if
+	// any). Overridden by WithTimeout.
+	CommandTimeout time.Duration
+
 	// Comment allows information comments about a specific client
 	// connection to be persisted with the configuration data.
 	Comment string
 
-	sshclient *ssh.Client
-	connected bool
-	lasterror error
+	// Vars are custom variables specific to this client available to
+	// command templates (see [WithTemplate]).
+	Vars map[string]any
+
+	// Labels are key/value pairs (role, region, env, etc.) used to
+	// target a subset of the clients of a Controller (see
+	// [Controller.Where] and [LabelQuery]).
+	Labels map[string]string
+
+	// Dialect is the command line syntax of the shell that runs commands
+	// on the target host (posix, cmd, or powershell) used when building
+	// command lines from arguments (see [Client.RunArgv]) and run
+	// options. If unset POSIX is used.
+	Dialect Dialect
+
+	// MaxSessions is the maximum number of sessions (commands) open at
+	// once on the target host after which new ones wait for one to
+	// close (see [Client.Queued]). It must match or be lower than the
+	// MaxSessions setting of the server (or "administratively
+	// prohibited" errors occur). If unset DefaultMaxSessions is used and
+	// if negative the number is unlimited. Changes after the first
+	// command has run have no effect. Commands run on any client (see
+	// [Controller.RunOnAny]) go to a client with a free slot when there
+	// is one unless they have affinity (see [WithAffinity]) or a key
+	// used by the Selector (see [KeyedSelector]).
+	MaxSessions int
+
+	// Weight is the relative share of commands sent to this client by
+	// the Weighted selector (see [Controller.Selector]). If unset
+	// a weight of 1 is used.
+	Weight int
+
+	mu           sync.Mutex
+	reconnecting sync.Mutex
+	sshclient    *ssh.Client
+	connected    bool
+	lasterror    error
+	latency      time.Duration
+	inflight     atomic.Int64
+	circuit      BreakerState
+	failures     int
+	opened       time.Time
+	probing      bool
+	healtherr    error
+	checked      time.Time
+	sessions     chan struct{}
+	queued       atomic.Int64
 }
 
 // SSHClient returns a pointer to the internal ssh.Client used for all
 // connections and sessions. Only set after first call to Connect.
-func (c *Client) SSHClient() *ssh.Client { return c.sshclient }
+func (c *Client) SSHClient() *ssh.Client {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sshclient
+}
 
 // Connected returns the last connection state of the internal SSH
 // client. This is set to true on Connect. This does not guarantee that
 // the current connection is still valid, just the last attempt.
-func (c *Client) Connected() bool { return c.connected }
+func (c *Client) Connected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.connected
+}
 
 // LastError returns the last error (if any) from an attempt to Connect.
 // When set Connected is guaranteed to return false.
-func (c *Client) LastError() error { return c.lasterror }
+func (c *Client) LastError() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lasterror
+}
 
 // Addr returns network address suitable for use in TCP/IP connection
 // strings. If the Port and Host are zero values returns empty host,
@@ -181,12 +257,15 @@ func (c *Client) Connect() error {
 	if c.Timeout != 0 {
 		timeout = c.Timeout
 	}
-	c.sshclient, err = ssh.Dial(`tcp`, c.Addr(), &ssh.ClientConfig{
+	sshclient, err := ssh.Dial(`tcp`, c.Addr(), &ssh.ClientConfig{
 		User:            c.User.Name,
 		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
 		HostKeyCallback: callback,
 		Timeout:         timeout,
 	})
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sshclient = sshclient
 	if err == nil {
 		c.connected = true
 	} else {
@@ -205,30 +284,12 @@ func (c *Client) Connect() error {
 // a timed-out connection or one that has been closed for any other
 // reason.) It is the responsibility of the called to respond to such
 // errors according to controller policy and associated method calls.
-func (c *Client) Run(cmd string, stdin []byte) (stdout, stderr string, err error) {
-	if c.sshclient == nil {
-		err = c.Connect()
-		if err != nil {
-			return
-		}
-	}
-	var sess *ssh.Session
-	sess, err = c.sshclient.NewSession()
-	if err != nil {
-		return
-	}
-	if len(stdin) > 0 {
-		sess.Stdin = bytes.NewReader(stdin)
-	}
-	_out := new(strings.Builder)
-	_err := new(strings.Builder)
-	sess.Stdout = _out
-	sess.Stderr = _err
-	sess.Run(cmd)
-	stdout = _out.String()
-	stderr = _err.String()
-	sess.Close()
-	return
+// Any RunOption (such as [WithEnv] or [WithDir]) is applied to the
+// session before the command is started.
+// See [Client.Exec] for the complete Result.
+func (c *Client) Run(cmd string, stdin []byte, opts ...RunOption) (stdout, stderr string, err error) {
+	r, err := c.Exec(cmd, stdin, opts...)
+	return r.Stdout, r.Stderr, err
 }
 
 // ---------------------------- Controller ----------------------------
@@ -242,6 +303,45 @@ func (c *Client) Run(cmd string, stdin []byte) (stdout, stderr string, err error
 //	ctl := new(ssh.Controller).Init(cl1,cl2)
 type Controller struct {
 	Clients []*Client
+
+	// CommandTimeout is the maximum time a command run by any Controller
+	// method may run on a client that has no CommandTimeout of its own
+	// (see [WithTimeout]). If unset commands may run forever.
+	CommandTimeout time.Duration
+
+	// Selector chooses the client for each command run on any client
+	// (see [Controller.RunOnAny]). If unset Random is used. It is never
+	// marshaled/unmarshaled to/from JSON/YAML and must be set in code.
+	Selector Selector `json:"-" yaml:"-"`
+
+	// Retry decides if a command run on any client that fails is
+	// attempted again on another. If unset DefaultRetryPolicy is used.
+	Retry *RetryPolicy
+
+	// Breaker is the circuit breaker policy applied to each client. If
+	// unset clients are selected for as long as they are connected.
+	Breaker *Breaker
+
+	// Health is the health check run on every client once started (see
+	// [Controller.StartHealthChecks]). If unset a default check is used.
+	Health *HealthCheck
+
+	onevent    func(Event)
+	hmu        sync.Mutex
+	hstop      chan struct{}
+	hwg        sync.WaitGroup
+	lmu        sync.Mutex
+	recent     []time.Duration
+	nextrecent int
+}
+
+// options returns opts preceded by the defaults of the Controller so
+// that they can be overridden by the caller.
+func (c *Controller) options(opts []RunOption) []RunOption {
+	defaults := func(cfg *runConfig) {
+		cfg.fallbackTimeout = c.CommandTimeout
+	}
+	return append([]RunOption{defaults}, opts...)
 }
 
 // Init returns a pointer to a Controller with the Clients list
@@ -258,9 +358,11 @@ func (c *Controller) Init(clients ...*Client) *Controller {
 	return c
 }
 
+// LogStatus logs the Status of every client on its own line.
 func (c *Controller) LogStatus() {
-	for _, c := range c.Clients {
-		log.Printf("%v %v %v\n", c.Dest(), c.connected, c.lasterror)
+	for _, s := range c.Status() {
+		log.Printf("%v %v %v %v %v/%v %v %v\n", s.Dest, s.Connected, s.Healthy,
+			s.Circuit, s.Sessions, s.Queued, s.LastError, s.HealthError)
 	}
 }
 
@@ -281,54 +383,130 @@ func (c *Controller) Connect() *Controller {
 }
 
 // RandomClient returns a random active client from the Clients list
-// skipping any that are not connected. Returns nil if no connected
-// clients are available.
+// skipping any that are not connected, unhealthy (see
+// [Controller.StartHealthChecks]), or have an open circuit (see
+// [Controller.Breaker]). Returns nil if no such clients are available.
 func (c *Controller) RandomClient() *Client {
-	var tried int
-	count := len(c.Clients)
-	if count == 0 {
-		return nil
+	return Random{}.Select(c.candidates(nil, true), ``)
+}
+
+// candidates returns the connected and healthy Clients (in order) not
+// in exclude that the circuit breaker allows to be selected. If spill
+// is true any with no free session slots are left out unless all of
+// them are full (see [Client.MaxSessions]).
+func (c *Controller) candidates(exclude map[*Client]bool, spill bool) []*Client {
+	list := make([]*Client, 0, len(c.Clients))
+	var free []*Client
+	for _, client := range c.Clients {
+		if client.Connected() && client.Healthy() && !exclude[client] &&
+			client.selectable(c.Breaker) {
+			list = append(list, client)
+			if !client.full() {
+				free = append(free, client)
+			}
+		}
+	}
+	if spill && len(free) > 0 {
+		return free
+	}
+	return list
+}
+
+// selectClient returns the client chosen by the Selector (or by
+// affinity, see [WithAffinity]) from the candidates not in exclude for
+// the key (see [WithKey]) of cfg (which may be nil) and admitted by the
+// circuit breaker or nil if there are none. Commands with affinity (or
+// a key used by a KeyedSelector) wait for a session slot of their client
+// rather than spilling over to another.
+func (c *Controller) selectClient(exclude map[*Client]bool, cfg *runConfig) *Client {
+	var selector Selector = Random{}
+	if c.Selector != nil {
+		selector = c.Selector
+	}
+	var key string
+	spill := true
+	if cfg != nil {
+		key = cfg.key
+		if cfg.affinity {
+			selector = Rendezvous{}
+			spill = false
+		}
+	}
+	if keyed, is := selector.(KeyedSelector); is && len(key) > 0 && keyed.Keyed() {
+		spill = false
 	}
-	n := rand.Intn(count)
 	for {
-		client := c.Clients[n]
-		if client.connected {
-			return client
+		candidates := c.candidates(exclude, spill)
+		if len(candidates) == 0 {
+			return nil
 		}
-		tried += 1
-		if tried > count {
+		client := selector.Select(candidates, key)
+		if client == nil {
 			return nil
 		}
-		n += 1
-		if n >= count {
-			n = 0
+		ok, changed := client.admit(c.Breaker)
+		if changed {
+			c.emit(Event{Kind: EventCircuit, Client: client})
 		}
+		if ok {
+			return client
+		}
+		skip := map[*Client]bool{client: true}
+		for k := range exclude {
+			skip[k] = true
+		}
+		exclude = skip
 	}
-	return nil
 }
 
-// RunOnAny calls [Client.Run] on a random client from the [Clients]
-// list. If error returned is of type [net.OpError] the
-// [Client.Connected] is set to false and the next client in the
-// [Clients] order is attempted. Then client producing the error has
-// [Client.Connect] called in a separate goroutine (which, if successful,
-// restores its [Client.Connected] status to true). If none of the
-// clients are connected then an [AllUnavailable] error is returned.
-// The cmd is always required but stdin may be nil.
-func (c *Controller) RunOnAny(cmd string, stdin []byte) (stdout, stderr string, err error) {
-
-	client := c.RandomClient()
-	if client == nil {
-		err = AllUnavailable{}
-		return
-	}
+// RunOnAny calls [Client.Run] on a client from the [Clients] list
+// chosen by the Selector (random by default). If the command fails with
+// an error the RetryPolicy of the Controller (or DefaultRetryPolicy)
+// considers retryable it is attempted again on another client not yet
+// tried until it succeeds, the policy runs out of attempts, or no
+// clients remain, and the last failure is returned. A client whose
+// connection fails has [Client.Connected] set to false and
+// [Client.Connect] called in a separate goroutine (which, if
+// successful, restores its [Client.Connected] status to true). If none
+// of the clients are connected then an [AllUnavailable] error is
+// returned. The cmd is always required but stdin may be nil. Any
+// RunOption is passed to [Client.Run]. Use [WithHedge] to also run
+// slow commands on a second client. See [Controller.ExecOnAny] for the
+// complete Result including every attempt.
+func (c *Controller) RunOnAny(cmd string, stdin []byte, opts ...RunOption) (stdout, stderr string, err error) {
+	r, err := c.ExecOnAny(cmd, stdin, opts...)
+	return r.Stdout, r.Stderr, err
+}
 
-	stdout, stderr, err = client.Run(cmd, stdin)
-	if _, is := err.(*net.OpError); is {
-		client.connected = false
-		go client.Connect()
-		c.RunOnAny(cmd, stdin)
+// ExecOnAny is the same as [Controller.RunOnAny] but returns the
+// complete Result (see [Client.Exec]), which is never nil, of the last
+// attempt along with all of them (see [Result.Attempts]).
+func (c *Controller) ExecOnAny(cmd string, stdin []byte, opts ...RunOption) (*Result, error) {
+	policy := DefaultRetryPolicy
+	if c.Retry != nil {
+		policy = *c.Retry
 	}
-
-	return
+	cfg := newRunConfig(opts)
+	tried := map[*Client]bool{}
+	var attempts []Attempt
+	var r *Result
+	for n := 0; policy.MaxAttempts <= 0 || n < policy.MaxAttempts; n++ {
+		time.Sleep(policy.backoff(n))
+		client := c.selectClient(tried, cfg)
+		if client == nil {
+			break
+		}
+		tried[client] = true
+		var tries []Attempt
+		r, tries = c.attempt(client, tried, cfg, cmd, stdin, opts)
+		attempts = append(attempts, tries...)
+		if !policy.retryable(r) {
+			break
+		}
+	}
+	if r == nil {
+		r = &Result{ExitCode: -1, Err: AllUnavailable{}}
+	}
+	r.Attempts = attempts
+	return r, r.Err
 }
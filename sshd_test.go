@@ -0,0 +1,113 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// testServer is a minimal SSH server on a local port that answers
+// every exec request (without running anything) by writing Stdout and
+// exiting with Status.
+type testServer struct {
+	Stdout string
+	Status uint32
+	ran    atomic.Int64
+	addr   *net.TCPAddr
+}
+
+// newTestServer starts a testServer that is stopped when the test ends.
+func newTestServer(t *testing.T, stdout string, status uint32) *testServer {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(ssh.ConnMetadata, ssh.PublicKey) (*ssh.Permissions, error) {
+			return nil, nil
+		},
+	}
+	config.AddHostKey(signer)
+	l, err := net.Listen(`tcp`, `127.0.0.1:0`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	s := &testServer{Stdout: stdout, Status: status, addr: l.Addr().(*net.TCPAddr)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn, config)
+		}
+	}()
+	return s
+}
+
+// Ran returns the number of commands the server has answered.
+func (s *testServer) Ran() int { return int(s.ran.Load()) }
+
+// Client returns a new connected Client for the server.
+func (s *testServer) Client(t *testing.T) *Client {
+	t.Helper()
+	key, err := os.ReadFile(`testdata/keys/user`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &Client{
+		Host: &Host{Addr: s.addr.IP.String()},
+		Port: s.addr.Port,
+		User: &User{Name: `test`, Key: string(key)},
+	}
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.SSHClient().Close() })
+	return c
+}
+
+func (s *testServer) serve(conn net.Conn, config *ssh.ServerConfig) {
+	defer conn.Close()
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newch := range chans {
+		if newch.ChannelType() != `session` {
+			newch.Reject(ssh.UnknownChannelType, `sessions only`)
+			continue
+		}
+		ch, reqs, err := newch.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			defer ch.Close()
+			for req := range reqs {
+				if req.Type != `exec` {
+					req.Reply(req.Type == `env`, nil)
+					continue
+				}
+				req.Reply(true, nil)
+				s.ran.Add(1)
+				ch.Write([]byte(s.Stdout))
+				status := struct{ Status uint32 }{s.Status}
+				ch.SendRequest(`exit-status`, false, ssh.Marshal(&status))
+				return
+			}
+		}()
+	}
+}
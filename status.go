@@ -0,0 +1,104 @@
+package ssh
+
+import "time"
+
+// ------------------------------ Status ------------------------------
+
+// ClientStatus is a snapshot of the state of a single Client of
+// a Controller (see [Controller.Status]). A ClientStatus may be safely
+// marshaled to JSON/YAML.
+type ClientStatus struct {
+	Dest        string
+	Connected   bool
+	LastError   string
+	Healthy     bool
+	HealthError string
+	LastCheck   time.Time
+	Circuit     BreakerState
+	Failures    int
+	InFlight    int
+	Sessions    int
+	Queued      int
+	Latency     time.Duration
+}
+
+// Status returns the status of every client in the Clients list (in
+// order).
+func (c *Controller) Status() []ClientStatus {
+	list := make([]ClientStatus, 0, len(c.Clients))
+	for _, client := range c.Clients {
+		list = append(list, client.status())
+	}
+	return list
+}
+
+func (c *Client) status() ClientStatus {
+	s := ClientStatus{
+		Dest:      c.Dest(),
+		Connected: c.Connected(),
+		Healthy:   c.Healthy(),
+		LastCheck: c.LastCheck(),
+		Circuit:   c.Circuit(),
+		Failures:  c.Failures(),
+		InFlight:  c.InFlight(),
+		Sessions:  c.Sessions(),
+		Queued:    c.Queued(),
+		Latency:   c.Latency(),
+	}
+	if err := c.LastError(); err != nil {
+		s.LastError = err.Error()
+	}
+	if err := c.HealthError(); err != nil {
+		s.HealthError = err.Error()
+	}
+	return s
+}
+
+// ------------------------------ Events ------------------------------
+
+// EventKind identifies what changed for an Event.
+type EventKind int
+
+const (
+	EventCircuit EventKind = iota + 1 // circuit breaker state changed
+	EventHealth                       // became healthy or unhealthy
+)
+
+// String returns the name of the kind of event.
+func (k EventKind) String() string {
+	switch k {
+	case EventCircuit:
+		return `circuit`
+	case EventHealth:
+		return `health`
+	}
+	return `unknown`
+}
+
+// Event is sent to the function set with [Controller.OnEvent] whenever
+// the state of one of its clients changes. Status is a snapshot of the
+// client taken right after the change and Err is the error (if any)
+// that caused it.
+type Event struct {
+	Time   time.Time
+	Kind   EventKind
+	Client *Client
+	Status ClientStatus
+	Err    error
+}
+
+// OnEvent sets the function called synchronously with every Event
+// (replacing any set before), which must not block. Pass nil to stop
+// receiving events. Must not be called while commands are running.
+func (c *Controller) OnEvent(fn func(Event)) { c.onevent = fn }
+
+// emit sends the event (with Time and Status set) to the function set
+// with OnEvent (if any).
+func (c *Controller) emit(e Event) {
+	if c.onevent == nil {
+		return
+	}
+	e.Time = time.Now()
+	e.Status = e.Client.status()
+	c.onevent(e)
+}
@@ -0,0 +1,77 @@
+package ssh
+
+import (
+	"strings"
+	"text/template"
+)
+
+// ----------------------------- Template -----------------------------
+
+// TemplateData is the data available to a command template (see
+// [WithTemplate]) when rendered for a specific Client.
+type TemplateData struct {
+	Client  *Client
+	Host    *Host
+	User    *User
+	Port    int // DefaultPort if the Client has none
+	Addr    string
+	Comment string
+	Vars    map[string]any
+}
+
+// WithTemplate treats the command as a text/template rendered for each
+// client it runs on (see [Client.Render]) with the given variables
+// available as .Vars, which makes it possible to send tailored commands
+// to every client with a single call to any Controller method. The
+// variables may be nil.
+func WithTemplate(vars map[string]any) RunOption {
+	return func(c *runConfig) {
+		c.template = true
+		c.vars = vars
+	}
+}
+
+// Render executes tmpl as a text/template with the TemplateData of the
+// Client (.Host.Addr, .User.Name, .Port, .Comment, etc.) and returns
+// the result. The variables in .Vars are those passed merged with (and
+// overridden by) the Vars of the Client. The quote and join functions
+// are available for quoting values in the Dialect of the Client (see
+// [Dialect.Quote] and [Dialect.Join]).
+//
+//	{{.Host.Addr}}:{{.Port}} {{quote .Comment}} {{.Vars.release}}
+func (c *Client) Render(tmpl string, vars map[string]any) (string, error) {
+	t, err := template.New(``).Option(`missingkey=error`).Funcs(template.FuncMap{
+		`quote`: c.Dialect.Quote,
+		`join`:  c.Dialect.Join,
+	}).Parse(tmpl)
+	if err != nil {
+		return ``, err
+	}
+	merged := map[string]any{}
+	for k, v := range vars {
+		merged[k] = v
+	}
+	for k, v := range c.Vars {
+		merged[k] = v
+	}
+	port := DefaultPort
+	if c.Port > 0 {
+		port = c.Port
+	}
+	data := TemplateData{
+		Client:  c,
+		Host:    c.Host,
+		User:    c.User,
+		Port:    port,
+		Comment: c.Comment,
+		Vars:    merged,
+	}
+	if c.Host != nil {
+		data.Addr = c.Addr()
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		return ``, err
+	}
+	return b.String(), nil
+}
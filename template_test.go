@@ -0,0 +1,29 @@
+package ssh_test
+
+import (
+	"fmt"
+
+	"github.com/rwxrob/ssh"
+)
+
+func ExampleClient_Render() {
+
+	client := &ssh.Client{
+		Host:    &ssh.Host{Addr: `web1.example.com`},
+		User:    &ssh.User{Name: `deploy`},
+		Comment: `primary web`,
+		Vars:    map[string]any{`role`: `web`},
+	}
+
+	tmpl := `echo {{.User.Name}}@{{.Host.Addr}}:{{.Port}} {{quote .Comment}} {{.Vars.role}} {{.Vars.release}}`
+
+	cmd, err := client.Render(tmpl, map[string]any{`release`: `v1.2.3`, `role`: `ignored`})
+	if err != nil {
+		fmt.Println(err)
+	}
+	fmt.Println(cmd)
+
+	// Output:
+	// echo deploy@web1.example.com:22 'primary web' web v1.2.3
+
+}